@@ -73,3 +73,50 @@ func BenchmarkDecodeAll(b *testing.B) {
 		})
 	}
 }
+
+// The bench types use no XMLName field so the same types work with both packages.
+type benchBundle struct {
+	Msgs []benchMsg `xml:"msg"`
+}
+
+type benchMsg struct {
+	ID      string    `xml:"id,attr"`
+	Desc    string    `xml:"desc,attr"`
+	Meaning string    `xml:"meaning,attr"`
+	Source  []string  `xml:"source"`
+	Ph      []benchPh `xml:"ph"`
+	Text    string    `xml:",chardata"`
+}
+
+type benchPh struct {
+	Name    string `xml:"name,attr"`
+	Example string `xml:"ex"`
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	f, err := ioutil.ReadFile("testdata/bench.xmb")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc      string
+		unmarshal func(data []byte, v interface{}) error
+	}{
+		{"go-xml", Unmarshal},
+		{"encoding_xml", stdxml.Unmarshal},
+	}
+
+	for _, tc := range testCases {
+		b.Run(tc.desc, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var bundle benchBundle
+				if err := tc.unmarshal(f, &bundle); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import "container/list"
+
+// lru orders the entries of a cache keeping up to max entries, from the most to the least recently
+// used. The cache maps each key to the element returned by add.
+type lru struct {
+	max   int
+	order list.List
+}
+
+type lruEntry struct {
+	key, value interface{}
+}
+
+// get marks e as the most recently used entry and returns its value.
+func (l *lru) get(e *list.Element) interface{} {
+	l.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value
+}
+
+// add inserts an entry as the most recently used. Once max entries are held, the least recently used
+// one is replaced and its key returned, which the cache must remove. max must be positive.
+func (l *lru) add(key, value interface{}) (e *list.Element, evicted interface{}) {
+	if l.order.Len() < l.max {
+		return l.order.PushFront(&lruEntry{key, value}), nil
+	}
+	e = l.order.Back()
+	entry := e.Value.(*lruEntry)
+	evicted, entry.key, entry.value = entry.key, key, value
+	l.order.MoveToFront(e)
+	return e, evicted
+}
+
+// maxCachedNames bounds the number of entries in each nameCache of a Decoder.
+const maxCachedNames = 4096
+
+// nameCache maps keys holding *Name pointers to values, keeping only the maxCachedNames entries used
+// most recently. Names passed to DecodeElement, or dropped by a bounded NameTable, aren't interned,
+// so a Decoder would otherwise cache a new entry for each of them forever.
+//
+// The zero value is an empty cache.
+type nameCache struct {
+	entries map[interface{}]*list.Element
+	lru     lru
+}
+
+// get returns the value cached for key, and whether it was found.
+func (c *nameCache) get(key interface{}) (interface{}, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return c.lru.get(e), true
+}
+
+// put caches value for key, evicting the least recently used entry when the cache is full.
+func (c *nameCache) put(key, value interface{}) {
+	if c.entries == nil {
+		c.entries = make(map[interface{}]*list.Element)
+		c.lru.max = maxCachedNames
+	}
+	e, evicted := c.lru.add(key, value)
+	if evicted != nil {
+		delete(c.entries, evicted)
+	}
+	c.entries[key] = e
+}
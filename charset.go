@@ -0,0 +1,182 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+
+	// utf16BEDecl and utf16LEDecl are the `<?` starting an XML declaration without byte order mark.
+	utf16BEDecl = []byte{0, '<', 0, '?'}
+	utf16LEDecl = []byte{'<', 0, '?', 0}
+)
+
+// detectEncoding looks for a byte order mark, or a UTF-16 encoded `<?` at the start of the input,
+// and switches decoding to match.
+func (d *Decoder) detectEncoding() {
+	d.detected = true
+	// A read error is returned by the next read instead.
+	b, _ := d.r.Peek(4)
+	switch {
+	case bytes.HasPrefix(b, utf8BOM):
+		d.r.Discard(len(utf8BOM))
+		d.charset = "utf-8"
+	case bytes.HasPrefix(b, utf16BEBOM):
+		d.r.Discard(len(utf16BEBOM))
+		d.decodeWith("utf-16", utf16Decoder(binary.BigEndian))
+	case bytes.HasPrefix(b, utf16LEBOM):
+		d.r.Discard(len(utf16LEBOM))
+		d.decodeWith("utf-16", utf16Decoder(binary.LittleEndian))
+	case bytes.Equal(b, utf16BEDecl):
+		d.decodeWith("utf-16", utf16Decoder(binary.BigEndian))
+	case bytes.Equal(b, utf16LEDecl):
+		d.decodeWith("utf-16", utf16Decoder(binary.LittleEndian))
+	}
+}
+
+// switchEncoding switches decoding to the charset named by the XML declaration, unless the encoding
+// was already detected from the start of the input.
+func (d *Decoder) switchEncoding(charset string) error {
+	if d.charset != "" {
+		return nil
+	}
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		// ASCII is a subset of UTF-8.
+	case "iso-8859-1", "iso_8859-1", "iso8859-1", "latin1", "latin-1", "l1":
+		d.decodeWith(charset, decodeLatin1)
+	case "windows-1252", "cp1252":
+		d.decodeWith(charset, decodeWindows1252)
+	case "utf-16", "utf-16be", "utf-16le":
+		return fmt.Errorf("%w %q, the declaration is not UTF-16 encoded", UnsupportedEncoding, charset)
+	default:
+		if d.CharsetReader == nil {
+			return fmt.Errorf("%w %q, Decoder.CharsetReader is not set", UnsupportedEncoding, charset)
+		}
+		r, err := d.CharsetReader(charset, d.r)
+		if err != nil {
+			return fmt.Errorf("charset %q: %w", charset, err)
+		}
+		if r == nil {
+			return fmt.Errorf("%w %q, Decoder.CharsetReader returned a nil reader", UnsupportedEncoding, charset)
+		}
+		d.r = bufio.NewReader(r)
+	}
+	d.charset = charset
+	return nil
+}
+
+// decodeWith replaces the input with one that converts the rest of it into UTF-8 using decode.
+func (d *Decoder) decodeWith(charset string, decode func(*bufio.Reader) (rune, error)) {
+	d.charset = charset
+	d.r = bufio.NewReader(&utf8Reader{src: d.r, decode: decode})
+}
+
+// utf8Reader converts the runes read by decode from src back into UTF-8.
+type utf8Reader struct {
+	src    *bufio.Reader
+	decode func(*bufio.Reader) (rune, error)
+
+	// pending holds the bytes of a rune that didn't fit in the last Read.
+	pending []byte
+}
+
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	// Stop once the buffered input is consumed so Read doesn't block waiting for more.
+	for n < len(p) && (n == 0 || u.src.Buffered() > 0) {
+		r, err := u.decode(u.src)
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if l := utf8.RuneLen(r); n+l > len(p) {
+			var buf [utf8.UTFMax]byte
+			utf8.EncodeRune(buf[:], r)
+			c := copy(p[n:], buf[:l])
+			u.pending = append(u.pending[:0], buf[c:l]...)
+			return n + c, nil
+		}
+		n += utf8.EncodeRune(p[n:], r)
+	}
+	return n, nil
+}
+
+// decodeLatin1 reads an ISO-8859-1 character, which maps every byte to the same code point.
+func decodeLatin1(src *bufio.Reader) (rune, error) {
+	b, err := src.ReadByte()
+	return rune(b), err
+}
+
+// decodeWindows1252 reads a Windows-1252 character, which is like ISO-8859-1 except for the
+// printable characters in the 0x80-0x9F range.
+func decodeWindows1252(src *bufio.Reader) (rune, error) {
+	b, err := src.ReadByte()
+	if b >= 0x80 && b < 0xA0 {
+		return windows1252[b-0x80], err
+	}
+	return rune(b), err
+}
+
+// windows1252 maps the 0x80-0x9F range of Windows-1252, the bytes undefined by it keep their
+// ISO-8859-1 control characters.
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// utf16Decoder returns a function that reads UTF-16 characters in the given byte order. Unpaired
+// surrogates are replaced by utf8.RuneError.
+func utf16Decoder(order binary.ByteOrder) func(*bufio.Reader) (rune, error) {
+	return func(src *bufio.Reader) (rune, error) {
+		b, err := src.Peek(2)
+		if len(b) < 2 {
+			if len(b) == 1 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		src.Discard(2)
+		r := rune(order.Uint16(b))
+		if !utf16.IsSurrogate(r) {
+			return r, nil
+		}
+		// Only consume the next unit when it completes the surrogate pair.
+		if b, _ := src.Peek(2); len(b) == 2 {
+			if dec := utf16.DecodeRune(r, rune(order.Uint16(b))); dec != utf8.RuneError {
+				src.Discard(2)
+				return dec, nil
+			}
+		}
+		return utf8.RuneError, nil
+	}
+}
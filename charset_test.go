@@ -0,0 +1,186 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes s as UTF-16 in the given byte order, with the byte order mark when bom is set.
+func encodeUTF16(s string, order binary.ByteOrder, bom bool) []byte {
+	var b bytes.Buffer
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	binary.Write(&b, order, units)
+	return b.Bytes()
+}
+
+// upperReader is a CharsetReader for a fake charset that is decoded by upper casing the input.
+func upperReader(charset string, input io.Reader) (io.Reader, error) {
+	if charset != "x-upper" {
+		return nil, errors.New("unknown charset")
+	}
+	b, err := ioutil.ReadAll(input)
+	return bytes.NewReader(bytes.ToUpper(b)), err
+}
+
+func TestDecodeCharset(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input []byte
+	}{
+		{
+			desc:  "utf-8",
+			input: []byte(`<?xml version="1.0" encoding="UTF-8"?><a b="é€">é€😀</a>`),
+		},
+		{
+			desc:  "utf-8 bom",
+			input: append([]byte{0xEF, 0xBB, 0xBF}, `<?xml version="1.0"?><a b="é€">é€😀</a>`...),
+		},
+		{
+			desc:  "utf-16le bom",
+			input: encodeUTF16(`<?xml version="1.0" encoding="UTF-16"?><a b="é€">é€😀</a>`, binary.LittleEndian, true),
+		},
+		{
+			desc:  "utf-16be bom",
+			input: encodeUTF16(`<a b="é€">é€😀</a>`, binary.BigEndian, true),
+		},
+		{
+			desc:  "utf-16be without bom",
+			input: encodeUTF16(`<?xml version="1.0" encoding="UTF-16"?><a b="é€">é€😀</a>`, binary.BigEndian, false),
+		},
+		{
+			desc:  "iso-8859-1",
+			input: []byte("<?xml version='1.0' encoding='ISO-8859-1'?><a b=\"\xe9&#x20AC;\">\xe9&#x20AC;&#x1F600;</a>"),
+		},
+		{
+			desc:  "windows-1252",
+			input: []byte("<?xml version='1.0' encoding='windows-1252'?><a b=\"\xe9\x80\">\xe9\x80&#x1F600;</a>"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(bytes.NewReader(tc.input))
+			var attr, text string
+			for {
+				tok, err := d.Token()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch tok := tok.(type) {
+				case *StartTag:
+					attr = tok.Attr[0].Value
+				case *CharData:
+					text += string(tok.Data)
+				}
+			}
+			if attr != "é€" || text != "é€😀" {
+				t.Errorf("got attr %q text %q, want %q and %q", attr, text, "é€", "é€😀")
+			}
+		})
+	}
+}
+
+func TestUTF8ReaderShortReads(t *testing.T) {
+	const want = "aé€😀\uFFFDb"
+	// The unpaired surrogate before "b" is replaced.
+	input := append(encodeUTF16("aé€😀", binary.LittleEndian, false), 0x00, 0xD8, 'b', 0)
+	r := &utf8Reader{src: bufio.NewReader(bytes.NewReader(input)), decode: utf16Decoder(binary.LittleEndian)}
+	got, err := ioutil.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecodeCharsetReader(t *testing.T) {
+	const input = `<?xml version="1.0" encoding="x-upper"?><a>foo</a>`
+	d := NewDecoder(strings.NewReader(input))
+	d.CharsetReader = upperReader
+	var got string
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok, ok := tok.(*StartTag); ok {
+			got += tok.Name.Local()
+		}
+		if tok, ok := tok.(*CharData); ok {
+			got += string(tok.Data)
+		}
+	}
+	if got != "AFOO" {
+		t.Errorf("got %q, want %q", got, "AFOO")
+	}
+}
+
+func TestDecodeCharsetErrors(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		input         string
+		charsetReader func(string, io.Reader) (io.Reader, error)
+		want          string
+	}{
+		{
+			desc:  "unknown",
+			input: `<?xml version="1.0" encoding="Shift_JIS"?><a/>`,
+			want:  `unsupported encoding "Shift_JIS", Decoder.CharsetReader is not set`,
+		},
+		{
+			desc:          "charset reader error",
+			input:         `<?xml version="1.0" encoding="Shift_JIS"?><a/>`,
+			charsetReader: upperReader,
+			want:          `charset "Shift_JIS": unknown charset`,
+		},
+		{
+			desc:  "utf-16 declared in ascii",
+			input: `<?xml version="1.0" encoding="UTF-16"?><a/>`,
+			want:  `unsupported encoding "UTF-16", the declaration is not UTF-16 encoded`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.CharsetReader = tc.charsetReader
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
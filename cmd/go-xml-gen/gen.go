@@ -0,0 +1,778 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const xmlImportPath = "github.com/Goodwine/go-xml"
+
+type basicKind int
+
+const (
+	notBasic basicKind = iota
+	kString
+	kBytes
+	kBool
+	kInt
+	kUint
+	kFloat
+)
+
+// basicKinds maps the predeclared Go types to the kind of value they hold and their size in bits,
+// zero meaning the size of int.
+var basicKinds = map[string]struct {
+	kind basicKind
+	bits int
+}{
+	"string":  {kString, 0},
+	"bool":    {kBool, 0},
+	"int":     {kInt, 0},
+	"int8":    {kInt, 8},
+	"int16":   {kInt, 16},
+	"int32":   {kInt, 32},
+	"rune":    {kInt, 32},
+	"int64":   {kInt, 64},
+	"uint":    {kUint, 0},
+	"uint8":   {kUint, 8},
+	"byte":    {kUint, 8},
+	"uint16":  {kUint, 16},
+	"uint32":  {kUint, 32},
+	"uint64":  {kUint, 64},
+	"uintptr": {kUint, 0},
+	"float32": {kFloat, 32},
+	"float64": {kFloat, 64},
+}
+
+type fieldMode int
+
+const (
+	mElement fieldMode = iota
+	mAttr
+	mCharData
+)
+
+// fieldType describes the Go type of a field like `[]*T`.
+type fieldType struct {
+	// expr is the Go expression of the base type T, like "string" or "time.Time".
+	expr string
+	kind basicKind
+	bits int
+
+	slice bool
+	ptr   bool
+
+	// generated is set when T is one of the structs with generated methods.
+	generated bool
+}
+
+type field struct {
+	name      string
+	mode      fieldMode
+	omitEmpty bool
+	typ       fieldType
+
+	// nameIdx is the index of the XML name of the field in the NameSet of attributes or elements.
+	nameIdx int
+}
+
+type structType struct {
+	name   string
+	fields []*field
+
+	// xmlName is the XMLName field, and xmlIdent the name in its tag if any.
+	xmlName  *field
+	xmlIdent string
+
+	// The identifiers of attributes and elements, the NameSet of each is kept separate so the first
+	// match of a name is always the field of the right kind.
+	attrs []string
+	elems []string
+}
+
+type generator struct {
+	buf bytes.Buffer
+
+	// xmlPkg is the identifier used by the input file for the go-xml package.
+	xmlPkg  string
+	decls   map[string]ast.Expr
+	structs map[string]bool
+
+	// imports maps the identifiers imported by the input to their path, used records the ones needed
+	// by the generated code.
+	imports map[string]string
+	used    map[string]bool
+}
+
+// generate parses the Go file and returns the formatted source with the generated methods for the
+// given types, or all struct types if types is empty.
+func generate(filename string, types []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		decls:   make(map[string]ast.Expr),
+		structs: make(map[string]bool),
+		imports: make(map[string]string),
+		used:    make(map[string]bool),
+	}
+	for _, imp := range file.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		ident := path.Base(p)
+		if p == xmlImportPath {
+			ident = "xml"
+		}
+		if imp.Name != nil {
+			ident = imp.Name.Name
+		}
+		g.imports[ident] = p
+		if p == xmlImportPath {
+			g.xmlPkg = ident
+		}
+	}
+
+	var order []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		g.decls[spec.Name.Name] = spec.Type
+		if _, ok := spec.Type.(*ast.StructType); ok {
+			order = append(order, spec.Name.Name)
+		}
+		return false
+	})
+	if len(types) == 0 {
+		types = order
+	}
+	for _, name := range types {
+		if _, ok := g.decls[name].(*ast.StructType); !ok {
+			return nil, fmt.Errorf("%s is not a struct type declared in %s", name, filename)
+		}
+		g.structs[name] = true
+	}
+
+	var structs []*structType
+	for _, name := range types {
+		st, err := g.parseStruct(name, g.decls[name].(*ast.StructType))
+		if err != nil {
+			return nil, err
+		}
+		structs = append(structs, st)
+	}
+
+	for _, st := range structs {
+		g.genNameSets(st)
+		g.genDecode(st)
+		g.genEncode(st)
+	}
+
+	body := g.buf.Bytes()
+	g.buf = bytes.Buffer{}
+	g.p("// Code generated by go-xml-gen. DO NOT EDIT.\n\n")
+	g.p("package %s\n\n", file.Name.Name)
+	g.genImports(body)
+	g.buf.Write(body)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+// p writes formatted code into the generated output.
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// genImports writes the import block with the packages referenced by body.
+func (g *generator) genImports(body []byte) {
+	uses := func(pkg string) bool {
+		return regexp.MustCompile(`\b` + pkg + `\.`).Match(body)
+	}
+	var paths []string
+	for _, pkg := range []string{"errors", "fmt", "io", "strconv", "strings"} {
+		if uses(pkg) {
+			paths = append(paths, strconv.Quote(pkg))
+		}
+	}
+	var extra []string
+	for ident := range g.used {
+		p := g.imports[ident]
+		if !uses(ident) {
+			// Types decoded in place with reflection don't need to be named.
+			continue
+		}
+		if path.Base(p) == ident {
+			extra = append(extra, strconv.Quote(p))
+		} else {
+			extra = append(extra, ident+" "+strconv.Quote(p))
+		}
+	}
+	sort.Strings(extra)
+
+	g.p("import (\n")
+	for _, p := range append(paths, extra...) {
+		g.p("%s\n", p)
+	}
+	g.p("\n%q\n)\n\n", xmlImportPath)
+}
+
+// parseStruct reads the fields and tags of the struct.
+func (g *generator) parseStruct(name string, s *ast.StructType) (*structType, error) {
+	st := &structType{name: name}
+	addIdent := func(idents *[]string, ident string) (int, error) {
+		for _, id := range *idents {
+			if id == ident {
+				return 0, fmt.Errorf("%s: more than one field named %q", name, ident)
+			}
+		}
+		*idents = append(*idents, ident)
+		return len(*idents) - 1, nil
+	}
+
+	for _, f := range s.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+		var tag string
+		if f.Tag != nil {
+			t, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(t).Get("xml")
+		}
+		for _, n := range f.Names {
+			if !n.IsExported() || tag == "-" {
+				continue
+			}
+			fd, ident, err := parseTag(n.Name, tag)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", name, n.Name, err)
+			}
+
+			if n.Name == "XMLName" {
+				if !g.isXMLName(f.Type, &fd.typ) {
+					return nil, fmt.Errorf("%s.XMLName: must be of type xml.Name or *xml.Name", name)
+				}
+				st.xmlName, st.xmlIdent = fd, ident
+				continue
+			}
+
+			if fd.typ, err = g.resolve(f.Type); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", name, n.Name, err)
+			}
+			if fd.mode != mElement && fd.typ.kind == notBasic {
+				return nil, fmt.Errorf("%s.%s: type %s is only supported for elements", name, n.Name, fd.typ.expr)
+			}
+			if fd.mode == mCharData && (fd.typ.slice || fd.typ.ptr) {
+				return nil, fmt.Errorf("%s.%s: chardata must not be a pointer or slice", name, n.Name)
+			}
+			switch fd.mode {
+			case mAttr:
+				fd.nameIdx, err = addIdent(&st.attrs, ident)
+			case mElement:
+				fd.nameIdx, err = addIdent(&st.elems, ident)
+			}
+			if err != nil {
+				return nil, err
+			}
+			st.fields = append(st.fields, fd)
+		}
+	}
+	return st, nil
+}
+
+// parseTag reads a tag with the format `[namespace ]name[,flag...]` and returns the field and its
+// identifier as written in XML.
+func parseTag(name, tag string) (*field, string, error) {
+	fd := &field{name: name}
+	var ns string
+	if i := strings.Index(tag, " "); i >= 0 {
+		ns, tag = tag[:i], tag[i+1:]
+	}
+	tokens := strings.Split(tag, ",")
+	tag = tokens[0]
+	for _, flag := range tokens[1:] {
+		switch flag {
+		case "attr":
+			fd.mode = mAttr
+		case "chardata":
+			fd.mode = mCharData
+		case "omitempty":
+			fd.omitEmpty = true
+		case "innerxml", "comment", "any":
+			return nil, "", fmt.Errorf("the %s modifier is not supported", flag)
+		default:
+			return nil, "", fmt.Errorf("invalid modifier %q", flag)
+		}
+	}
+	if strings.Contains(tag, ">") {
+		return nil, "", fmt.Errorf("nested paths like %q are not supported", tag)
+	}
+	if fd.mode == mCharData && tag != "" {
+		return nil, "", fmt.Errorf("chardata must not have a name")
+	}
+	if tag == "" && name != "XMLName" {
+		tag = name
+	}
+	if ns != "" {
+		if tag == "" {
+			return nil, "", fmt.Errorf("namespace without name")
+		}
+		tag = ns + ":" + tag
+	}
+	return fd, tag, nil
+}
+
+// isXMLName reports whether the expression is xml.Name or *xml.Name.
+func (g *generator) isXMLName(e ast.Expr, typ *fieldType) bool {
+	if s, ok := e.(*ast.StarExpr); ok {
+		typ.ptr = true
+		e = s.X
+	}
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Name" {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && g.xmlPkg != "" && x.Name == g.xmlPkg
+}
+
+// resolve describes the type expression of a field.
+func (g *generator) resolve(e ast.Expr) (fieldType, error) {
+	var t fieldType
+	if a, ok := e.(*ast.ArrayType); ok && a.Len == nil && !isByte(a.Elt) {
+		t.slice = true
+		e = a.Elt
+	}
+	if s, ok := e.(*ast.StarExpr); ok {
+		t.ptr = true
+		e = s.X
+	}
+
+	switch x := e.(type) {
+	case *ast.ArrayType:
+		if x.Len != nil || !isByte(x.Elt) {
+			return t, fmt.Errorf("unsupported type %s", exprString(e))
+		}
+		t.expr, t.kind = "[]byte", kBytes
+	case *ast.Ident:
+		t.expr = x.Name
+		if b, ok := basicKinds[x.Name]; ok {
+			t.kind, t.bits = b.kind, b.bits
+			break
+		}
+		if g.structs[x.Name] {
+			t.generated = true
+			break
+		}
+		// Named types based on basic types are converted.
+		switch u := g.decls[x.Name].(type) {
+		case *ast.Ident:
+			if b, ok := basicKinds[u.Name]; ok {
+				t.kind, t.bits = b.kind, b.bits
+			}
+		case *ast.ArrayType:
+			if u.Len == nil && isByte(u.Elt) {
+				t.kind = kBytes
+			}
+		}
+	case *ast.SelectorExpr:
+		pkg, ok := x.X.(*ast.Ident)
+		if !ok || g.imports[pkg.Name] == "" {
+			return t, fmt.Errorf("unsupported type %s", exprString(e))
+		}
+		g.used[pkg.Name] = true
+		t.expr = pkg.Name + "." + x.Sel.Name
+	default:
+		return t, fmt.Errorf("unsupported type %s", exprString(e))
+	}
+	return t, nil
+}
+
+func isByte(e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && (id.Name == "byte" || id.Name == "uint8")
+}
+
+func exprString(e ast.Expr) string {
+	var b bytes.Buffer
+	format.Node(&b, token.NewFileSet(), e)
+	return b.String()
+}
+
+// nameVarRE matches uses of the name variable in the body of DecodeXML.
+var nameVarRE = regexp.MustCompile(`[^.]\bname\b`)
+
+// attrSetVar and elemSetVar return the names of the variables holding the NameSets of the struct.
+func attrSetVar(st *structType) string {
+	return "xmlAttrs" + strings.ToUpper(st.name[:1]) + st.name[1:]
+}
+
+func elemSetVar(st *structType) string {
+	return "xmlElems" + strings.ToUpper(st.name[:1]) + st.name[1:]
+}
+
+func (g *generator) genNameSets(st *structType) {
+	for _, set := range []struct {
+		name   string
+		idents []string
+	}{{attrSetVar(st), st.attrs}, {elemSetVar(st), st.elems}} {
+		if len(set.idents) == 0 {
+			continue
+		}
+		var quoted []string
+		for _, ident := range set.idents {
+			quoted = append(quoted, strconv.Quote(ident))
+		}
+		g.p("var %s = xml.NewNameSet(%s)\n", set.name, strings.Join(quoted, ", "))
+	}
+	g.p("\n")
+}
+
+// genDecode writes the DecodeXML method of the struct.
+func (g *generator) genDecode(st *structType) {
+	g.p("// DecodeXML implements xml.Unmarshaler.\n")
+	g.p("func (v *%s) DecodeXML(d *xml.Decoder, start *xml.StartTag) error {\n", st.name)
+	// The body is written first to find out which variables it needs.
+	header := g.buf
+	g.buf = bytes.Buffer{}
+	defer func() {
+		body := g.buf
+		g.buf = header
+		if nameVarRE.Match(body.Bytes()) {
+			// The StartTag is reused by the Decoder, keep the name for error messages.
+			g.p("name := start.Name\n")
+		}
+		g.p("\n")
+		g.buf.Write(body.Bytes())
+	}()
+
+	if f := st.xmlName; f != nil {
+		if ident := st.xmlIdent; ident != "" {
+			// Checked once per element, there is no need for a NameSet.
+			if strings.Contains(ident, ":") {
+				g.p("if name.String() != %q {\n", ident)
+			} else {
+				g.p("if name.Local() != %q {\n", ident)
+			}
+			g.p("return fmt.Errorf(\"expected element <%%s> but have <%%s>\", %q, name)\n}\n", ident)
+		}
+		if f.typ.ptr {
+			g.p("v.%s = name\n", f.name)
+		} else {
+			g.p("v.%s = *name\n", f.name)
+		}
+	}
+
+	var attrs, elems []*field
+	var chardata *field
+	for _, f := range st.fields {
+		switch f.mode {
+		case mAttr:
+			attrs = append(attrs, f)
+		case mElement:
+			elems = append(elems, f)
+		case mCharData:
+			if chardata == nil {
+				chardata = f
+			}
+		}
+	}
+
+	if len(attrs) > 0 {
+		g.p("for _, a := range start.Attr {\n")
+		g.p("switch d.NameIndex(%s, a.Name) {\n", attrSetVar(st))
+		for _, f := range attrs {
+			g.p("case %d:\n", f.nameIdx)
+			errf := `fmt.Errorf("%w reading attribute %s on <%s>", err, a.Name, name)`
+			g.genParse(f, "a.Value", "", errf)
+		}
+		g.p("}\n}\n\n")
+	}
+
+	if chardata != nil {
+		g.p("var text []byte\n")
+	}
+	g.p("for {\n")
+	g.p("tok, err := d.Token()\n")
+	g.p("if err != nil {\n")
+	g.p("if errors.Is(err, io.EOF) {\nreturn io.ErrUnexpectedEOF\n}\n")
+	g.p("return err\n}\n")
+	if len(elems) > 0 || chardata != nil {
+		g.p("switch t := tok.(type) {\n")
+	} else {
+		g.p("switch tok.(type) {\n")
+	}
+	g.p("case *xml.StartTag:\n")
+	if len(elems) > 0 {
+		g.p("switch d.NameIndex(%s, t.Name) {\n", elemSetVar(st))
+		for _, f := range elems {
+			g.p("case %d:\n", f.nameIdx)
+			g.genDecodeElement(f)
+		}
+		g.p("default:\n")
+	}
+	g.p("if err := d.Skip(); err != nil {\nreturn err\n}\n")
+	if len(elems) > 0 {
+		g.p("}\n")
+	}
+	if chardata != nil {
+		g.p("case *xml.CharData:\n")
+		g.p("text = append(text, t.Data...)\n")
+	}
+	g.p("case *xml.CloseTag:\n")
+	if chardata != nil {
+		g.genParse(chardata, "string(text)", "text", `fmt.Errorf("%w reading <%s>", err, name)`)
+	}
+	g.p("return nil\n")
+	g.p("}\n}\n}\n\n")
+}
+
+// genDecodeElement writes the code decoding the element t into the field.
+func (g *generator) genDecodeElement(f *field) {
+	const errf = `fmt.Errorf("%w in <%s>", err, name)`
+	if f.typ.kind != notBasic {
+		g.p("b, err := d.ReadText()\n")
+		g.p("if err != nil {\nreturn err\n}\n")
+		g.genParse(f, "string(b)", "b", `fmt.Errorf("%w reading <%s> in <%s>", err, t.Name, name)`)
+		return
+	}
+
+	decode := func(target string) string {
+		if f.typ.generated {
+			return target + ".DecodeXML(d, t)"
+		}
+		return "d.DecodeElement(&" + target + ", t)"
+	}
+	switch {
+	case f.typ.slice:
+		g.p("var x %s\n", f.typ.expr)
+		g.p("if err := %s; err != nil {\nreturn %s\n}\n", decode("x"), errf)
+		g.genStore(f, "x")
+	case f.typ.ptr:
+		g.p("if v.%s == nil {\nv.%[1]s = new(%s)\n}\n", f.name, f.typ.expr)
+		if f.typ.generated {
+			g.p("if err := v.%s.DecodeXML(d, t); err != nil {\nreturn %s\n}\n", f.name, errf)
+		} else {
+			g.p("if err := d.DecodeElement(v.%s, t); err != nil {\nreturn %s\n}\n", f.name, errf)
+		}
+	default:
+		g.p("if err := %s; err != nil {\nreturn %s\n}\n", decode("v."+f.name), errf)
+	}
+}
+
+// genParse writes the code parsing the basic value of the field from the string expression src, or
+// from the []byte expression srcBytes if not empty, and stores it into the field.
+func (g *generator) genParse(f *field, src, srcBytes, errf string) {
+	t := f.typ
+	switch t.kind {
+	case kString:
+		g.p("x := %s\n", convert(t.expr, "string", src))
+	case kBytes:
+		if srcBytes != "" {
+			src = fmt.Sprintf("append(make([]byte, 0, len(%s)), %[1]s...)", srcBytes)
+		}
+		g.p("x := %s\n", convert(t.expr, "[]byte", src))
+	default:
+		var parse, parsed string
+		switch t.kind {
+		case kBool:
+			parse, parsed = "strconv.ParseBool(s)", "bool"
+		case kInt:
+			parse, parsed = fmt.Sprintf("strconv.ParseInt(s, 10, %d)", t.bits), "int64"
+		case kUint:
+			parse, parsed = fmt.Sprintf("strconv.ParseUint(s, 10, %d)", t.bits), "uint64"
+		case kFloat:
+			parse, parsed = fmt.Sprintf("strconv.ParseFloat(s, %d)", t.bits), "float64"
+		}
+		g.p("var x %s\n", t.expr)
+		g.p("if s := strings.TrimSpace(%s); s != \"\" {\n", src)
+		g.p("p, err := %s\n", parse)
+		g.p("if err != nil {\nreturn %s\n}\n", errf)
+		g.p("x = %s\n", convert(t.expr, parsed, "p"))
+		g.p("}\n")
+	}
+	g.genStore(f, "x")
+}
+
+// convert returns the Go expression converting value from the type from into the type to.
+func convert(to, from, value string) string {
+	if to == from {
+		return value
+	}
+	return to + "(" + value + ")"
+}
+
+// genStore writes the code storing the local variable x into the field.
+func (g *generator) genStore(f *field, x string) {
+	if f.typ.ptr {
+		x = "&" + x
+	}
+	if f.typ.slice {
+		g.p("v.%s = append(v.%[1]s, %s)\n", f.name, x)
+	} else {
+		g.p("v.%s = %s\n", f.name, x)
+	}
+}
+
+// genEncode writes the MarshalXML method of the struct.
+func (g *generator) genEncode(st *structType) {
+	g.p("// MarshalXML implements xml.Marshaler.\n")
+	g.p("func (v *%s) MarshalXML(e *xml.Encoder, start xml.StartTag) error {\n", st.name)
+	if len(st.attrs) > 0 {
+		g.p("attrNames := %s.Names()\n", attrSetVar(st))
+	}
+	if len(st.elems) > 0 {
+		g.p("elemNames := %s.Names()\n", elemSetVar(st))
+	}
+	for _, f := range st.fields {
+		if f.mode != mAttr && f.typ.kind >= kBool {
+			g.p("var scratch []byte\n")
+			break
+		}
+	}
+	g.p("\n")
+
+	for _, f := range st.fields {
+		if f.mode != mAttr {
+			continue
+		}
+		g.genEncodeValue(f, "v."+f.name, true, func(value string) {
+			g.p("start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[%d], Value: %s})\n", f.nameIdx, value)
+		})
+	}
+
+	g.p("if err := e.EncodeToken(&start); err != nil {\nreturn err\n}\n")
+	for _, f := range st.fields {
+		switch f.mode {
+		case mCharData:
+			g.genEncodeValue(f, "v."+f.name, false, func(value string) {
+				g.p("if err := e.EncodeToken(&xml.CharData{Data: %s}); err != nil {\nreturn err\n}\n", value)
+			})
+		case mElement:
+			g.genEncodeElement(f)
+		}
+	}
+	g.p("return e.EncodeToken(&xml.CloseTag{Name: start.Name})\n")
+	g.p("}\n\n")
+}
+
+// genEncodeElement writes the code encoding the element field.
+func (g *generator) genEncodeElement(f *field) {
+	target := "v." + f.name
+	if f.typ.slice {
+		g.p("for i := range v.%s {\n", f.name)
+		target = "v." + f.name + "[i]"
+	}
+
+	if f.typ.kind != notBasic {
+		g.genEncodeValue(f, target, false, func(value string) {
+			g.p("if err := e.EncodeTextElement(elemNames[%d], %s); err != nil {\nreturn err\n}\n", f.nameIdx, value)
+		})
+	} else {
+		if f.typ.ptr {
+			g.p("if %s != nil {\n", target)
+		}
+		start := fmt.Sprintf("xml.StartTag{Name: elemNames[%d]}", f.nameIdx)
+		if f.typ.generated {
+			g.p("if err := %s.MarshalXML(e, %s); err != nil {\nreturn err\n}\n", target, start)
+		} else {
+			g.p("if err := e.EncodeElement(%s, %s); err != nil {\nreturn err\n}\n", target, start)
+		}
+		if f.typ.ptr {
+			g.p("}\n")
+		}
+	}
+
+	if f.typ.slice {
+		g.p("}\n")
+	}
+}
+
+// genEncodeValue writes the code formatting the basic value held by target, and passes the
+// resulting expression to write, either a string or a []byte. Nil pointers and empty values with
+// omitempty are skipped.
+func (g *generator) genEncodeValue(f *field, target string, asString bool, write func(value string)) {
+	t := f.typ
+	var closing int
+	if t.ptr {
+		g.p("if %s != nil {\n", target)
+		closing++
+		target = "*" + target
+	}
+	if f.omitEmpty && !f.typ.slice {
+		switch t.kind {
+		case kString, kBytes:
+			g.p("if len(%s) > 0 {\n", target)
+		case kBool:
+			g.p("if %s {\n", target)
+		default:
+			g.p("if %s != 0 {\n", target)
+		}
+		closing++
+	}
+
+	switch {
+	case (t.kind == kString || t.kind == kBytes) && asString:
+		write(convert("string", t.expr, target))
+	case t.kind == kString || t.kind == kBytes:
+		write(convert("[]byte", t.expr, target))
+	default:
+		var format string
+		switch t.kind {
+		case kBool:
+			format = "Bool(%s" + convert("bool", t.expr, target) + ")"
+		case kInt:
+			format = "Int(%s" + convert("int64", t.expr, target) + ", 10)"
+		case kUint:
+			format = "Uint(%s" + convert("uint64", t.expr, target) + ", 10)"
+		case kFloat:
+			format = "Float(%s" + convert("float64", t.expr, target) + ", 'g', -1, " + strconv.Itoa(t.bits) + ")"
+		}
+		if asString {
+			write("strconv.Format" + fmt.Sprintf(format, ""))
+		} else {
+			g.p("scratch = strconv.Append"+format+"\n", "scratch[:0], ")
+			write("scratch")
+		}
+	}
+
+	for ; closing > 0; closing-- {
+		g.p("}\n")
+	}
+}
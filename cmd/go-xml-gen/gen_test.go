@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGenerateUpToDate(t *testing.T) {
+	got, err := generate("internal/xmb/xmb.go", []string{"Bundle", "Msg", "Placeholder", "Meta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("internal/xmb/xmb_xml.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(want), string(got)); diff != "" {
+		t.Error("internal/xmb/xmb_xml.go is out of date, run go generate (-want +got)\n", diff)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	testCases := []struct {
+		desc string
+		src  string
+		want string
+	}{
+		{"not a struct", `type T int`, "T is not a struct type"},
+		{"embedded", `type T struct{ U }; type U struct{}`, "embedded fields are not supported"},
+		{"innerxml", "type T struct{ A string `xml:\",innerxml\"` }", "the innerxml modifier is not supported"},
+		{"path", "type T struct{ A string `xml:\"a>b\"` }", `nested paths like "a>b" are not supported`},
+		{"attr type", "type T struct{ A U `xml:\"a,attr\"` }; type U struct{}", "type U is only supported for elements"},
+		{"map", `type T struct{ A map[string]string }`, "unsupported type map[string]string"},
+		{"duplicate", "type T struct{ A string `xml:\"a\"`; B int `xml:\"a\"` }", `more than one field named "a"`},
+		{"xmlname type", "type T struct{ XMLName string `xml:\"t\"` }", "must be of type xml.Name or *xml.Name"},
+	}
+
+	dir, err := ioutil.TempDir("", "go-xml-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			filename := filepath.Join(dir, "input.go")
+			if err := ioutil.WriteFile(filename, []byte("package p\n\n"+tc.src+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := generate(filename, []string{"T"})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xmb holds XML Message Bundle types used to test the code generated by go-xml-gen.
+package xmb
+
+import (
+	"time"
+
+	"github.com/Goodwine/go-xml"
+)
+
+//go:generate go run github.com/Goodwine/go-xml/cmd/go-xml-gen -type Bundle,Msg,Placeholder,Meta $GOFILE
+
+// Bundle is the root element of an XMB file.
+type Bundle struct {
+	XMLName xml.Name `xml:"messagebundle"`
+	Msgs    []Msg    `xml:"msg"`
+	Meta    *Meta    `xml:"meta"`
+}
+
+// Msg is a translatable message.
+type Msg struct {
+	ID      string        `xml:"id,attr"`
+	Desc    string        `xml:"desc,attr"`
+	Meaning string        `xml:"meaning,attr,omitempty"`
+	Source  []string      `xml:"source"`
+	Ph      []Placeholder `xml:"ph"`
+	Text    string        `xml:",chardata"`
+}
+
+// Placeholder is a variable part of a message.
+type Placeholder struct {
+	Name    string `xml:"name,attr"`
+	Example string `xml:"ex"`
+}
+
+// Priority ranks bundles, it's a named type to test conversions.
+type Priority int8
+
+// Meta has fields of every kind supported by the generator.
+type Meta struct {
+	Version  uint16    `xml:"version,attr"`
+	Draft    bool      `xml:"draft,attr,omitempty"`
+	Ratio    float64   `xml:"ratio,attr,omitempty"`
+	Priority *Priority `xml:"priority"`
+	Counts   []int     `xml:"count"`
+	Checksum []byte    `xml:"checksum"`
+	Updated  time.Time `xml:"updated"`
+	Note     string    `xml:"x note,omitempty"`
+}
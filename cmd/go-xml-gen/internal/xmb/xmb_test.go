@@ -0,0 +1,172 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xmb
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	stdxml "encoding/xml"
+
+	"github.com/Goodwine/go-xml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const corpus = "../../../../testdata/bench.xmb"
+
+// The reflect types mirror the generated ones without DecodeXML methods, so they are decoded with
+// reflection.
+type reflectBundle struct {
+	XMLName xml.Name     `xml:"messagebundle"`
+	Msgs    []reflectMsg `xml:"msg"`
+}
+
+type reflectMsg struct {
+	ID      string        `xml:"id,attr"`
+	Desc    string        `xml:"desc,attr"`
+	Meaning string        `xml:"meaning,attr,omitempty"`
+	Source  []string      `xml:"source"`
+	Ph      []Placeholder `xml:"ph"`
+	Text    string        `xml:",chardata"`
+}
+
+func TestDecodeCorpus(t *testing.T) {
+	f, err := ioutil.ReadFile(corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, got Bundle
+	if err := stdxml.Unmarshal(f, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(f, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.XMLName.Local() != "messagebundle" {
+		t.Errorf("XMLName: %q, want messagebundle", got.XMLName.Local())
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Bundle{}, "XMLName")); diff != "" {
+		t.Error("generated DecodeXML diff vs encoding/xml (-want +got)\n", diff)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	priority := Priority(-3)
+	want := Bundle{
+		Msgs: []Msg{
+			{ID: "1", Desc: "flying mammal", Source: []string{"a.go"}, Ph: []Placeholder{{Name: "x", Example: "ex"}}, Text: "Bat"},
+			{ID: "2", Desc: "baseball item", Meaning: "sport"},
+		},
+		Meta: &Meta{
+			Version:  2,
+			Draft:    true,
+			Ratio:    0.25,
+			Priority: &priority,
+			Counts:   []int{1, 2},
+			Checksum: []byte("abc"),
+			Updated:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Note:     "note",
+		},
+	}
+
+	const wantXML = `<messagebundle>` +
+		`<msg id="1" desc="flying mammal"><source>a.go</source><ph name="x"><ex>ex</ex></ph>Bat</msg>` +
+		`<msg id="2" desc="baseball item" meaning="sport"></msg>` +
+		`<meta version="2" draft="true" ratio="0.25"><priority>-3</priority><count>1</count><count>2</count>` +
+		`<checksum>abc</checksum><updated>2020-01-02T03:04:05Z</updated><x:note>note</x:note></meta>` +
+		`</messagebundle>`
+
+	data, err := xml.Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(wantXML, string(data)); diff != "" {
+		t.Error("generated MarshalXML diff (-want +got)\n", diff)
+	}
+
+	var got Bundle
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Bundle{}, "XMLName")); diff != "" {
+		t.Error("round trip diff (-want +got)\n", diff)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  string
+	}{
+		{"wrong root", `<bundle></bundle>`, "expected element <messagebundle> but have <bundle>"},
+		{"bad attribute", `<messagebundle><meta version="-1"></meta></messagebundle>`, "reading attribute version on <meta>"},
+		{"bad element", `<messagebundle><meta><count>a</count></meta></messagebundle>`, "reading <count> in <meta>"},
+		{"unexpected EOF", `<messagebundle><msg>`, "unexpected EOF"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var b Bundle
+			err := xml.Unmarshal([]byte(tc.input), &b)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	f, err := ioutil.ReadFile(corpus)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc      string
+		unmarshal func() error
+	}{
+		{"generated", func() error {
+			var v Bundle
+			return xml.Unmarshal(f, &v)
+		}},
+		{"reflection", func() error {
+			var v reflectBundle
+			return xml.Unmarshal(f, &v)
+		}},
+		{"encoding_xml", func() error {
+			var v Bundle
+			return stdxml.Unmarshal(f, &v)
+		}},
+	}
+
+	for _, tc := range testCases {
+		b.Run(tc.desc, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := tc.unmarshal(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
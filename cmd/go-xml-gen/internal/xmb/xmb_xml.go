@@ -0,0 +1,378 @@
+// Code generated by go-xml-gen. DO NOT EDIT.
+
+package xmb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Goodwine/go-xml"
+)
+
+var xmlElemsBundle = xml.NewNameSet("msg", "meta")
+
+// DecodeXML implements xml.Unmarshaler.
+func (v *Bundle) DecodeXML(d *xml.Decoder, start *xml.StartTag) error {
+	name := start.Name
+
+	if name.Local() != "messagebundle" {
+		return fmt.Errorf("expected element <%s> but have <%s>", "messagebundle", name)
+	}
+	v.XMLName = *name
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch t := tok.(type) {
+		case *xml.StartTag:
+			switch d.NameIndex(xmlElemsBundle, t.Name) {
+			case 0:
+				var x Msg
+				if err := x.DecodeXML(d, t); err != nil {
+					return fmt.Errorf("%w in <%s>", err, name)
+				}
+				v.Msgs = append(v.Msgs, x)
+			case 1:
+				if v.Meta == nil {
+					v.Meta = new(Meta)
+				}
+				if err := v.Meta.DecodeXML(d, t); err != nil {
+					return fmt.Errorf("%w in <%s>", err, name)
+				}
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case *xml.CloseTag:
+			return nil
+		}
+	}
+}
+
+// MarshalXML implements xml.Marshaler.
+func (v *Bundle) MarshalXML(e *xml.Encoder, start xml.StartTag) error {
+	elemNames := xmlElemsBundle.Names()
+
+	if err := e.EncodeToken(&start); err != nil {
+		return err
+	}
+	for i := range v.Msgs {
+		if err := v.Msgs[i].MarshalXML(e, xml.StartTag{Name: elemNames[0]}); err != nil {
+			return err
+		}
+	}
+	if v.Meta != nil {
+		if err := v.Meta.MarshalXML(e, xml.StartTag{Name: elemNames[1]}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(&xml.CloseTag{Name: start.Name})
+}
+
+var xmlAttrsMsg = xml.NewNameSet("id", "desc", "meaning")
+var xmlElemsMsg = xml.NewNameSet("source", "ph")
+
+// DecodeXML implements xml.Unmarshaler.
+func (v *Msg) DecodeXML(d *xml.Decoder, start *xml.StartTag) error {
+	name := start.Name
+
+	for _, a := range start.Attr {
+		switch d.NameIndex(xmlAttrsMsg, a.Name) {
+		case 0:
+			x := a.Value
+			v.ID = x
+		case 1:
+			x := a.Value
+			v.Desc = x
+		case 2:
+			x := a.Value
+			v.Meaning = x
+		}
+	}
+
+	var text []byte
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch t := tok.(type) {
+		case *xml.StartTag:
+			switch d.NameIndex(xmlElemsMsg, t.Name) {
+			case 0:
+				b, err := d.ReadText()
+				if err != nil {
+					return err
+				}
+				x := string(b)
+				v.Source = append(v.Source, x)
+			case 1:
+				var x Placeholder
+				if err := x.DecodeXML(d, t); err != nil {
+					return fmt.Errorf("%w in <%s>", err, name)
+				}
+				v.Ph = append(v.Ph, x)
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case *xml.CharData:
+			text = append(text, t.Data...)
+		case *xml.CloseTag:
+			x := string(text)
+			v.Text = x
+			return nil
+		}
+	}
+}
+
+// MarshalXML implements xml.Marshaler.
+func (v *Msg) MarshalXML(e *xml.Encoder, start xml.StartTag) error {
+	attrNames := xmlAttrsMsg.Names()
+	elemNames := xmlElemsMsg.Names()
+
+	start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[0], Value: v.ID})
+	start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[1], Value: v.Desc})
+	if len(v.Meaning) > 0 {
+		start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[2], Value: v.Meaning})
+	}
+	if err := e.EncodeToken(&start); err != nil {
+		return err
+	}
+	for i := range v.Source {
+		if err := e.EncodeTextElement(elemNames[0], []byte(v.Source[i])); err != nil {
+			return err
+		}
+	}
+	for i := range v.Ph {
+		if err := v.Ph[i].MarshalXML(e, xml.StartTag{Name: elemNames[1]}); err != nil {
+			return err
+		}
+	}
+	if err := e.EncodeToken(&xml.CharData{Data: []byte(v.Text)}); err != nil {
+		return err
+	}
+	return e.EncodeToken(&xml.CloseTag{Name: start.Name})
+}
+
+var xmlAttrsPlaceholder = xml.NewNameSet("name")
+var xmlElemsPlaceholder = xml.NewNameSet("ex")
+
+// DecodeXML implements xml.Unmarshaler.
+func (v *Placeholder) DecodeXML(d *xml.Decoder, start *xml.StartTag) error {
+
+	for _, a := range start.Attr {
+		switch d.NameIndex(xmlAttrsPlaceholder, a.Name) {
+		case 0:
+			x := a.Value
+			v.Name = x
+		}
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch t := tok.(type) {
+		case *xml.StartTag:
+			switch d.NameIndex(xmlElemsPlaceholder, t.Name) {
+			case 0:
+				b, err := d.ReadText()
+				if err != nil {
+					return err
+				}
+				x := string(b)
+				v.Example = x
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case *xml.CloseTag:
+			return nil
+		}
+	}
+}
+
+// MarshalXML implements xml.Marshaler.
+func (v *Placeholder) MarshalXML(e *xml.Encoder, start xml.StartTag) error {
+	attrNames := xmlAttrsPlaceholder.Names()
+	elemNames := xmlElemsPlaceholder.Names()
+
+	start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[0], Value: v.Name})
+	if err := e.EncodeToken(&start); err != nil {
+		return err
+	}
+	if err := e.EncodeTextElement(elemNames[0], []byte(v.Example)); err != nil {
+		return err
+	}
+	return e.EncodeToken(&xml.CloseTag{Name: start.Name})
+}
+
+var xmlAttrsMeta = xml.NewNameSet("version", "draft", "ratio")
+var xmlElemsMeta = xml.NewNameSet("priority", "count", "checksum", "updated", "x:note")
+
+// DecodeXML implements xml.Unmarshaler.
+func (v *Meta) DecodeXML(d *xml.Decoder, start *xml.StartTag) error {
+	name := start.Name
+
+	for _, a := range start.Attr {
+		switch d.NameIndex(xmlAttrsMeta, a.Name) {
+		case 0:
+			var x uint16
+			if s := strings.TrimSpace(a.Value); s != "" {
+				p, err := strconv.ParseUint(s, 10, 16)
+				if err != nil {
+					return fmt.Errorf("%w reading attribute %s on <%s>", err, a.Name, name)
+				}
+				x = uint16(p)
+			}
+			v.Version = x
+		case 1:
+			var x bool
+			if s := strings.TrimSpace(a.Value); s != "" {
+				p, err := strconv.ParseBool(s)
+				if err != nil {
+					return fmt.Errorf("%w reading attribute %s on <%s>", err, a.Name, name)
+				}
+				x = p
+			}
+			v.Draft = x
+		case 2:
+			var x float64
+			if s := strings.TrimSpace(a.Value); s != "" {
+				p, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					return fmt.Errorf("%w reading attribute %s on <%s>", err, a.Name, name)
+				}
+				x = p
+			}
+			v.Ratio = x
+		}
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		switch t := tok.(type) {
+		case *xml.StartTag:
+			switch d.NameIndex(xmlElemsMeta, t.Name) {
+			case 0:
+				b, err := d.ReadText()
+				if err != nil {
+					return err
+				}
+				var x Priority
+				if s := strings.TrimSpace(string(b)); s != "" {
+					p, err := strconv.ParseInt(s, 10, 8)
+					if err != nil {
+						return fmt.Errorf("%w reading <%s> in <%s>", err, t.Name, name)
+					}
+					x = Priority(p)
+				}
+				v.Priority = &x
+			case 1:
+				b, err := d.ReadText()
+				if err != nil {
+					return err
+				}
+				var x int
+				if s := strings.TrimSpace(string(b)); s != "" {
+					p, err := strconv.ParseInt(s, 10, 0)
+					if err != nil {
+						return fmt.Errorf("%w reading <%s> in <%s>", err, t.Name, name)
+					}
+					x = int(p)
+				}
+				v.Counts = append(v.Counts, x)
+			case 2:
+				b, err := d.ReadText()
+				if err != nil {
+					return err
+				}
+				x := append(make([]byte, 0, len(b)), b...)
+				v.Checksum = x
+			case 3:
+				if err := d.DecodeElement(&v.Updated, t); err != nil {
+					return fmt.Errorf("%w in <%s>", err, name)
+				}
+			case 4:
+				b, err := d.ReadText()
+				if err != nil {
+					return err
+				}
+				x := string(b)
+				v.Note = x
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case *xml.CloseTag:
+			return nil
+		}
+	}
+}
+
+// MarshalXML implements xml.Marshaler.
+func (v *Meta) MarshalXML(e *xml.Encoder, start xml.StartTag) error {
+	attrNames := xmlAttrsMeta.Names()
+	elemNames := xmlElemsMeta.Names()
+	var scratch []byte
+
+	start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[0], Value: strconv.FormatUint(uint64(v.Version), 10)})
+	if v.Draft {
+		start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[1], Value: strconv.FormatBool(v.Draft)})
+	}
+	if v.Ratio != 0 {
+		start.Attr = append(start.Attr, &xml.Attr{Name: attrNames[2], Value: strconv.FormatFloat(v.Ratio, 'g', -1, 64)})
+	}
+	if err := e.EncodeToken(&start); err != nil {
+		return err
+	}
+	if v.Priority != nil {
+		scratch = strconv.AppendInt(scratch[:0], int64(*v.Priority), 10)
+		if err := e.EncodeTextElement(elemNames[0], scratch); err != nil {
+			return err
+		}
+	}
+	for i := range v.Counts {
+		scratch = strconv.AppendInt(scratch[:0], int64(v.Counts[i]), 10)
+		if err := e.EncodeTextElement(elemNames[1], scratch); err != nil {
+			return err
+		}
+	}
+	if err := e.EncodeTextElement(elemNames[2], v.Checksum); err != nil {
+		return err
+	}
+	if err := e.EncodeElement(v.Updated, xml.StartTag{Name: elemNames[3]}); err != nil {
+		return err
+	}
+	if len(v.Note) > 0 {
+		if err := e.EncodeTextElement(elemNames[4], []byte(v.Note)); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(&xml.CloseTag{Name: start.Name})
+}
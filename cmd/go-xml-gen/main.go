@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command go-xml-gen generates DecodeXML and MarshalXML methods for Go structs with `xml` tags, so
+// they implement `xml.Unmarshaler` and `xml.Marshaler` without using reflection.
+//
+// Usage:
+//
+//    go-xml-gen [-type T1,T2] [-o output.go] input.go
+//
+// Or with go generate:
+//
+//    //go:generate go-xml-gen -type Msg,Placeholder $GOFILE
+//
+// By default methods are generated for every struct type in the input file, and written into a
+// file named like the input with a "_xml.go" suffix.
+//
+// The generated code reads tokens with `Decoder.Token` and switches on the position of element and
+// attribute names within an `xml.NameSet`, which the Decoder caches for every interned `*Name`
+// pointer so names are only compared as strings the first time they are seen.
+//
+// Supported fields are strings, byte slices, booleans, integers, floats, and named types based on
+// them, as well as the struct types generated in the same run, pointers and slices of all of them.
+// Elements of any other type fall back to `Decoder.DecodeElement` and `Encoder.EncodeElement`. The
+// tag modifiers supported are `attr`, `chardata`, and `omitempty`. Nested paths like `a>b` and
+// the `innerxml`, `comment`, and `any` modifiers are not supported, use reflection for those types.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; default all structs in the file")
+	output    = flag.String("o", "", "output file name; default <input>_xml.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of go-xml-gen:\n")
+	fmt.Fprintf(os.Stderr, "\tgo-xml-gen [-type T1,T2] [-o output.go] input.go\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("go-xml-gen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+
+	src, err := generate(input, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(input, ".go") + "_xml.go"
+	}
+	if err := ioutil.WriteFile(out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// parseDeclaration parses the contents of an XML declaration like
+// `version="1.0" encoding="UTF-8" standalone="yes"` into decl. The version is required, and the
+// pseudo-attributes must appear in that order.
+func parseDeclaration(decl *Declaration, inst []byte) error {
+	*decl = Declaration{}
+
+	name, value, rest, err := pseudoAttr(inst)
+	if err != nil {
+		return err
+	}
+	if string(name) != "version" {
+		return fmt.Errorf("%w, expected version", InvalidDeclaration)
+	}
+	if !isValidVersion(value) {
+		return fmt.Errorf("%w, unsupported version %q", InvalidDeclaration, value)
+	}
+	decl.Version = string(value)
+
+	if name, value, rest, err = pseudoAttr(rest); err != nil {
+		return err
+	}
+	if string(name) == "encoding" {
+		if !isValidEncoding(value) {
+			return fmt.Errorf("%w, invalid encoding name %q", InvalidDeclaration, value)
+		}
+		decl.Encoding = string(value)
+		if name, value, rest, err = pseudoAttr(rest); err != nil {
+			return err
+		}
+	}
+	if string(name) == "standalone" {
+		switch string(value) {
+		case "yes":
+			decl.Standalone = true
+		case "no":
+		default:
+			return fmt.Errorf("%w, standalone must be \"yes\" or \"no\", got %q", InvalidDeclaration, value)
+		}
+		if name, _, _, err = pseudoAttr(rest); err != nil {
+			return err
+		}
+	}
+	if name != nil {
+		return fmt.Errorf("%w, unexpected %q", InvalidDeclaration, name)
+	}
+	return nil
+}
+
+// pseudoAttr reads the next `name="value"` pair in the XML declaration, name is nil when there are
+// no more pairs.
+func pseudoAttr(s []byte) (name, value, rest []byte, err error) {
+	s = bytes.TrimLeft(s, " \t\r\n")
+	if len(s) == 0 {
+		return nil, nil, nil, nil
+	}
+	i := 0
+	for i < len(s) && s[i] >= 'a' && s[i] <= 'z' {
+		i++
+	}
+	if i == 0 {
+		return nil, nil, nil, fmt.Errorf("%w, unexpected %q", InvalidDeclaration, s[0])
+	}
+	name, s = s[:i], bytes.TrimLeft(s[i:], " \t\r\n")
+	if len(s) == 0 || s[0] != '=' {
+		return nil, nil, nil, fmt.Errorf("%w, expected '=' after %s", InvalidDeclaration, name)
+	}
+	s = bytes.TrimLeft(s[1:], " \t\r\n")
+	if len(s) == 0 || (s[0] != '"' && s[0] != '\'') {
+		return nil, nil, nil, fmt.Errorf("%w, expected quoted value for %s", InvalidDeclaration, name)
+	}
+	end := bytes.IndexByte(s[1:], s[0])
+	if end < 0 {
+		return nil, nil, nil, fmt.Errorf("%w, unterminated value for %s", InvalidDeclaration, name)
+	}
+	value, rest = s[1:end+1], s[end+2:]
+	if len(rest) > 0 && !isDeclSpace(rest[0]) {
+		return nil, nil, nil, fmt.Errorf("%w, expected space after %s", InvalidDeclaration, name)
+	}
+	return name, value, rest, nil
+}
+
+func isDeclSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+// isValidVersion reports whether v looks like "1.0", any "1.x" version is accepted.
+func isValidVersion(v []byte) bool {
+	if len(v) < 3 || !bytes.HasPrefix(v, []byte("1.")) {
+		return false
+	}
+	for _, b := range v[2:] {
+		if b < '0' || b > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidEncoding reports whether enc is a valid encoding name like "UTF-8" or "ISO-8859-1".
+func isValidEncoding(enc []byte) bool {
+	if len(enc) == 0 || !isASCIILetter(rune(enc[0])) {
+		return false
+	}
+	for _, b := range enc[1:] {
+		if !isASCIILetter(rune(b)) && (b < '0' || b > '9') && b != '.' && b != '_' && b != '-' {
+			return false
+		}
+	}
+	return true
+}
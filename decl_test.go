@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTokenDeclaration(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  Declaration
+	}{
+		{
+			desc:  "version",
+			input: `<?xml version="1.0"?>`,
+			want:  Declaration{Version: "1.0"},
+		},
+		{
+			desc:  "all fields",
+			input: `<?xml version='1.1' encoding='ISO-8859-1' standalone='yes' ?>`,
+			want:  Declaration{Version: "1.1", Encoding: "ISO-8859-1", Standalone: true},
+		},
+		{
+			desc:  "spaces around equals",
+			input: "<?xml version = \"1.0\"\n\tstandalone = \"no\"?>",
+			want:  Declaration{Version: "1.0"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input + "<a/>"))
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := tok.(*ProcInst)
+			if p.Target != "xml" || p.Decl == nil {
+				t.Fatalf("got ProcInst %+v, want xml declaration", p)
+			}
+			if diff := cmp.Diff(tc.want, *p.Decl); diff != "" {
+				t.Error("Declaration diff (-want +got)\n", diff)
+			}
+		})
+	}
+}
+
+func TestTokenDeclarationErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  string
+	}{
+		{"not at start", ` <?xml version="1.0"?>`, "invalid XML declaration, it must be at the start of the document"},
+		{"after element", `<a/><?xml version="1.0"?>`, "invalid XML declaration, it must be at the start of the document"},
+		{"missing version", `<?xml encoding="UTF-8"?>`, "invalid XML declaration, expected version"},
+		{"empty", `<?xml?>`, "invalid XML declaration, expected version"},
+		{"bad version", `<?xml version="2.0"?>`, `invalid XML declaration, unsupported version "2.0"`},
+		{"bad encoding", `<?xml version="1.0" encoding="UTF 8"?>`, `invalid XML declaration, invalid encoding name "UTF 8"`},
+		{"bad standalone", `<?xml version="1.0" standalone="true"?>`, `standalone must be "yes" or "no", got "true"`},
+		{"wrong order", `<?xml version="1.0" standalone="yes" encoding="UTF-8"?>`, `invalid XML declaration, unexpected "encoding"`},
+		{"missing space", `<?xml version="1.0"encoding="UTF-8"?>`, "invalid XML declaration, expected space after version"},
+		{"missing equals", `<?xml version "1.0"?>`, "invalid XML declaration, expected '=' after version"},
+		{"unquoted", `<?xml version=1.0?>`, "invalid XML declaration, expected quoted value for version"},
+		{"unterminated", `<?xml version="1.0?>`, "invalid XML declaration, unterminated value for version"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if !errors.Is(err, InvalidDeclaration) {
+				t.Fatalf("err: '%v' want InvalidDeclaration", err)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
@@ -22,8 +22,7 @@ import (
 	"io"
 	"strings"
 	"unicode"
-
-	"github.com/google/triemap"
+	"unicode/utf8"
 )
 
 type decodeError string
@@ -37,8 +36,97 @@ const (
 	// UnexpectedChar is thrown when an unexpected rune or characters appears outside of an attribute
 	// value or CharData token.
 	UnexpectedChar decodeError = "unexpected char"
+
+	// InvalidEntity is thrown when an entity or character reference like `&amp;` or `&#38;` in
+	// CharData or an attribute value is malformed, unterminated, or unknown.
+	InvalidEntity decodeError = "invalid entity"
+
+	// InvalidDeclaration is thrown when the XML declaration like `<?xml version="1.0"?>` is malformed
+	// or doesn't appear at the start of the document.
+	InvalidDeclaration decodeError = "invalid XML declaration"
+
+	// UndeclaredPrefix is thrown when Namespaces is set and a tag or attribute uses a namespace
+	// prefix that isn't declared by an `xmlns:prefix` attribute in scope.
+	UndeclaredPrefix decodeError = "undeclared namespace prefix"
+
+	// InvalidNamespace is thrown when Namespaces is set and an `xmlns` attribute declares a namespace
+	// that isn't allowed, like an empty URI for a prefix or redefining the `xml` prefix.
+	InvalidNamespace decodeError = "invalid namespace declaration"
+
+	// UnsupportedEncoding is thrown when the XML declaration names a character encoding that isn't
+	// supported by default, and Decoder.CharsetReader isn't set or doesn't support it either.
+	UnsupportedEncoding decodeError = "unsupported encoding"
+
+	// InvalidDTD is thrown when ReadDTD is set and the `<!DOCTYPE ...>` declaration is malformed.
+	InvalidDTD decodeError = "invalid DTD"
+
+	// TokenTooLarge is thrown when a single token, like a CharData, Comment, or StartTag with its
+	// attributes, spans more input bytes than Decoder.MaxTokenBytes.
+	TokenTooLarge decodeError = "token too large"
+
+	// TooManyAttrs is thrown when a StartTag has more attributes than Decoder.MaxAttrs.
+	TooManyAttrs decodeError = "too many attributes"
+
+	// TooDeep is thrown when elements are nested deeper than Decoder.MaxDepth.
+	TooDeep decodeError = "elements nested too deep"
+
+	// InputTooLarge is thrown when the input is longer than Decoder.MaxInputBytes.
+	InputTooLarge decodeError = "input too large"
+
+	// TooManyNames is thrown when the input has more distinct tag, attribute, and processing
+	// instruction names than Decoder.MaxNames.
+	TooManyNames decodeError = "too many distinct names"
+
+	// EntityLimit is thrown when expanding an entity declared by the DTD nests too deep or produces
+	// too much text, like the "billion laughs" attack does.
+	EntityLimit decodeError = "entity expansion limit exceeded"
 )
 
+// Position is a location in the input, Row and Col start at 1.
+type Position struct {
+	Row int
+	Col int
+}
+
+// String returns the position like "row: 1 col: 2", matching the other decoding errors.
+func (p Position) String() string {
+	return fmt.Sprintf("row: %d col: %d", p.Row, p.Col)
+}
+
+// openTag is an element that was opened but not closed yet.
+type openTag struct {
+	name *Name
+	pos  Position
+}
+
+// TagMismatchError is returned in Strict mode when a CloseTag doesn't match the last open StartTag.
+// Start is nil when there was no open element at all.
+type TagMismatchError struct {
+	Start    *Name
+	StartPos Position
+	Close    *Name
+	ClosePos Position
+}
+
+func (err *TagMismatchError) Error() string {
+	if err.Start == nil {
+		return fmt.Sprintf("close tag </%s> at %s without start tag", err.Close, err.ClosePos)
+	}
+	return fmt.Sprintf("close tag </%s> at %s does not match start tag <%s> at %s",
+		err.Close, err.ClosePos, err.Start, err.StartPos)
+}
+
+// UnclosedTagError is returned in Strict mode when the input ends while elements are still open,
+// Name and Pos refer to the innermost of them.
+type UnclosedTagError struct {
+	Name *Name
+	Pos  Position
+}
+
+func (err *UnclosedTagError) Error() string {
+	return fmt.Sprintf("unclosed tag <%s> at %s", err.Name, err.Pos)
+}
+
 // Decoder processes an XML input and generates tokens or processes into a given struct.
 type Decoder struct {
 	// ReadComment enables reading and returning back the comment contents. Otherwise returns an empty
@@ -51,10 +139,165 @@ type Decoder struct {
 	// Note that we DO NOT process directives, we simply return back the string within `<! ... >`
 	ReadDirective bool
 
-	r   io.RuneReader
+	// ReadProcInst enables reading and returning back the processing instruction contents in
+	// ProcInst.Inst. Otherwise only the Target is set. Disabled by default.
+	//
+	// The XML declaration like `<?xml version="1.0"?>` is always parsed into ProcInst.Decl.
+	ReadProcInst bool
+
+	// Validate enables checking the document against the DTD in its `<!DOCTYPE ...>` declaration,
+	// returning a ValidationError when an element doesn't follow its declared content model, has an
+	// undeclared or invalid attribute, or is missing a required one, when an ID is used twice, or an
+	// IDREF doesn't match any ID. Validate implies ReadDTD and Strict. Disabled by default.
+	Validate bool
+
+	// ReadDTD enables parsing the `<!DOCTYPE ...>` directive and its internal subset, which is then
+	// returned by Decoder.DTD. The directive is still returned as a Directive token. Disabled by
+	// default.
+	//
+	// The general entities declared by the DTD like `<!ENTITY name "value">` are then expanded in
+	// CharData and attribute values, and the attributes with a default value in an `<!ATTLIST>` are
+	// added to every StartTag missing them. Markup within entity values is returned as text.
+	ReadDTD bool
+
+	// RawEntities disables decoding entities and character references like `&amp;` or `&#38;` in
+	// CharData and attribute values, they are returned verbatim instead. Disabled by default.
+	RawEntities bool
+
+	// Entity maps entity names to their replacement text, in addition to the predefined XML entities
+	// like `&amp;`. Use HTMLEntity to decode the named references defined by HTML5, like `&nbsp;`.
+	//
+	// Entity names are limited to 32 characters.
+	Entity map[string]string
+
+	// KeepUnknownEntities returns entities that are neither predefined nor in the Entity map
+	// verbatim, like `&foo;`, instead of failing with an InvalidEntity error. Malformed entities are
+	// still an error. Disabled by default.
+	KeepUnknownEntities bool
+
+	// Strict enables verifying that every CloseTag matches the last open StartTag, returning a
+	// TagMismatchError otherwise, and that no element is left open when the input ends, returning an
+	// UnclosedTagError. Disabled by default.
+	Strict bool
+
+	// PreserveWhitespace keeps the whitespace in CharData exactly as written, including newlines and
+	// tabs. Otherwise every run of whitespace is collapsed into a single space, except within elements
+	// with the `xml:space="preserve"` attribute, and their children up to an `xml:space="default"`.
+	// Disabled by default.
+	PreserveWhitespace bool
+
+	// XML11 decodes the input with the XML 1.1 rules, which also allow character references to control
+	// characters like `&#x1;`. It's enabled as well by a `<?xml version="1.1"?>` declaration. Names
+	// follow the same rules on both versions since XML 1.0 fifth edition, so tags like `<h1>` or
+	// `<título>` are always accepted. Disabled by default.
+	XML11 bool
+
+	// Namespaces resolves the prefix of every tag and attribute Name to the namespace URI declared by
+	// the `xmlns` and `xmlns:prefix` attributes in scope, see Name.URI. Resolved names are interned
+	// too, so the same tag under different namespaces gets a different pointer. Using a prefix that
+	// isn't declared returns an UndeclaredPrefix error. Disabled by default.
+	Namespaces bool
+
+	// CharsetReader, if set, returns a reader that converts input from the given charset into UTF-8.
+	// It's called when the XML declaration names an encoding like `<?xml version="1.0"
+	// encoding="Shift_JIS"?>`. UTF-8, UTF-16 with a byte order mark, ISO-8859-1, and Windows-1252 are
+	// decoded without it.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// HTMLMode enables parsing HTML-like documents leniently:
+	//
+	//    * Attribute values may be unquoted, like <foo bar=baz>.
+	//    * Void elements like <br> or <img> are self-closing, a CloseTag is emitted right after them.
+	//    * End tags without an open element, like </br> or a stray </span>, are dropped.
+	//    * The contents of <script> and <style> are returned verbatim as a single CharData.
+	//    * Elements with an optional end tag like <p> or <li> are closed implicitly by the tags that
+	//      end them in HTML, or when their parent or the input ends.
+	//
+	// Tag names are matched ignoring case. Set Entity to HTMLEntity to decode named references like
+	// `&nbsp;` too. Disabled by default.
+	HTMLMode bool
+
+	// The following limits protect the Decoder against hostile inputs, each one fails with its own
+	// error when exceeded. Zero means no limit, the default.
+	//
+	// MaxTokenBytes is the number of input bytes a single token can span, TokenTooLarge otherwise.
+	// MaxAttrs is the number of attributes a StartTag can have, TooManyAttrs otherwise.
+	// MaxDepth is the number of elements that can be open at once, TooDeep otherwise.
+	// MaxInputBytes is the number of bytes read from the input before any charset conversion,
+	// InputTooLarge otherwise.
+	// MaxNames is the number of names missing from the NameTable when read, plus the distinct names
+	// resolved to a namespace URI when Namespaces is set, TooManyNames otherwise. Names that a
+	// bounded NameTable doesn't keep, or evicted from it, count again every time they are read, so a
+	// small LRU table can reach MaxNames on a long document with few distinct names.
+	MaxTokenBytes int
+	MaxAttrs      int
+	MaxDepth      int
+	MaxInputBytes int64
+	MaxNames      int
+
+	// NameTable interns the names read by the Decoder, so repeated names are returned as the same
+	// *Name pointer. It defaults to a table owned by the Decoder that keeps every name, which can be
+	// replaced by a bounded table like NewLRUNameTable to cap the memory used by a long-lived
+	// Decoder, or by a NewSharedNameTable shared between decoders.
+	NameTable NameTable
+
+	r   *bufio.Reader
 	row int
 	col int
 
+	// input counts the bytes read from the input, see MaxInputBytes.
+	input *countingReader
+
+	// offset is the number of UTF-8 bytes read so far, and tokenStart its value when the current
+	// token started, see MaxTokenBytes.
+	offset     int64
+	tokenStart int64
+
+	// nameCount is the number of names interned, see MaxNames.
+	nameCount int
+
+	// depth is the number of elements currently open.
+	depth int
+
+	// stack holds the elements currently open in Strict mode, tagPos is the position of the last `<`.
+	stack  []openTag
+	tagPos Position
+
+	// textPos is the position where the last CharData started, unless it was a CDATA section.
+	textPos Position
+
+	// detected is set once the start of the input was checked for a byte order mark, and charset
+	// records the encoding the input is being decoded from, see detectEncoding.
+	detected bool
+	charset  string
+
+	// dtd is the DOCTYPE parsed when ReadDTD is set, expanded counts the bytes written by expanding
+	// its entities so far.
+	dtd      *DTD
+	expanded int
+
+	// valid checks the tokens against the DTD when Validate is set.
+	valid *validator
+
+	// declared11 is set by a `<?xml version="1.1"?>` declaration, see XML11.
+	declared11 bool
+
+	// ns holds the namespace declarations in scope, and nsMarks the length of ns when each open
+	// element started. nsNames interns the names resolved to a namespace URI.
+	ns      []nsBinding
+	nsMarks []int
+	nsNames map[nsNameKey]*Name
+
+	// html holds the elements currently open in HTMLMode, see htmlToken. pending is a token that was
+	// read but is returned after the CloseTag of an element it ends implicitly, and rawText is set
+	// after the StartTag of an element whose contents are raw text, like <script>.
+	html    []*Name
+	pending Token
+	rawText *Name
+
+	// spaces holds whether each element currently open is within `xml:space="preserve"`.
+	spaces []bool
+
 	// startedTag indicates whether the current last token consumed an open angle bracket (<)
 	startedTag bool
 
@@ -66,7 +309,26 @@ type Decoder struct {
 	// token, identifier like tag names or attributes, and string values.
 	buf   *bytes.Buffer
 	attrs *attrBuffer
-	names triemap.RuneSliceMap
+
+	// names is the NameTable owned by the Decoder, used unless NameTable is set.
+	names NameTable
+
+	// saved records the raw input while reading an `xml:",innerxml"` field, it is nil otherwise.
+	saved    *bytes.Buffer
+	savedBuf bytes.Buffer
+
+	// fields caches the struct fields matching each interned name, see lookupFields.
+	fields nameCache
+
+	// nameIndex caches the position of each interned name within every NameSet, see NameIndex.
+	nameIndex nameCache
+
+	// entityBuf holds the name of the entity being read, see readEntity.
+	entityBuf []byte
+
+	// valueBuf accumulates the CharData of an element being unmarshaled into a basic type, so its
+	// contents survive the tokens that follow it.
+	valueBuf []byte
 
 	// The following are object buffers to save on allocations by reusing the same instance every
 	// time the Decoder.Token function is called.
@@ -75,9 +337,11 @@ type Decoder struct {
 	// times.
 	startTagBuf  StartTag
 	closeTagBuf  CloseTag
+	implicitBuf  CloseTag
 	charDataBuf  CharData
 	commentBuf   Comment
 	procInstBuf  ProcInst
+	declBuf      Declaration
 	directiveBuf Directive
 }
 
@@ -87,27 +351,148 @@ func NewDecoder(r io.Reader) *Decoder {
 	attrBuf.growBy(30)
 	var buf bytes.Buffer
 	buf.Grow(1000)
+	input := &countingReader{r: r}
 	return &Decoder{
-		r:     bufio.NewReader(r),
+		r:     bufio.NewReader(input),
+		input: input,
 		buf:   &buf,
 		attrs: &attrBuf,
 	}
 }
 
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // Token will decode the next token from the current XML position.
 //
 // The token is meant to be processed BEFORE the next token is called.
 // Contents of previous tokens can be modified at any time during tokenization.
 func (d *Decoder) Token() (Token, error) {
-	// TODO: Add option to Decoder so Token pushes/pops tag names onto a stack to verify tags match 1:1.
-	t, err := d.token()
+	var t Token
+	var err error
+	if d.HTMLMode {
+		t, err = d.htmlToken()
+	} else {
+		t, err = d.token()
+	}
 	if err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("%w at row: %d col: %d", err, d.row+1, d.col)
 	}
+	if err != nil && d.isStrict() && len(d.stack) > 0 {
+		top := d.stack[len(d.stack)-1]
+		return nil, &UnclosedTagError{Name: top.name, Pos: top.pos}
+	}
+	switch t := t.(type) {
+	case *StartTag:
+		if d.MaxDepth > 0 && d.depth >= d.MaxDepth {
+			return nil, fmt.Errorf("%w, more than %d levels at %s", TooDeep, d.MaxDepth, d.tagPos)
+		}
+		if d.dtd != nil {
+			if err := d.addDefaultAttrs(t); err != nil {
+				return nil, fmt.Errorf("%w at %s", err, d.tagPos)
+			}
+		}
+		if d.Namespaces {
+			if err := d.pushNamespaces(t); err != nil {
+				return nil, fmt.Errorf("%w at %s", err, d.tagPos)
+			}
+		}
+		if d.isStrict() {
+			d.stack = append(d.stack, openTag{name: t.Name, pos: d.tagPos})
+		}
+		d.pushSpace(t)
+		d.depth++
+	case *CloseTag:
+		if d.Namespaces {
+			name, err := d.resolveName(t.Name, true)
+			if err != nil {
+				return nil, fmt.Errorf("%w at %s", err, d.tagPos)
+			}
+			t.Name = name
+			d.popNamespaces()
+		}
+		if d.isStrict() {
+			if err := d.popTag(t.Name); err != nil {
+				return nil, err
+			}
+		}
+		if len(d.spaces) > 0 {
+			d.spaces = d.spaces[:len(d.spaces)-1]
+		}
+		if d.depth > 0 {
+			// Unless Strict is set, stray close tags are allowed but must not make room for more levels.
+			d.depth--
+		}
+	}
+	if d.Validate {
+		if err := d.validate(t); err != nil {
+			return nil, err
+		}
+	}
 	return t, err
 }
 
+// isStrict reports whether start and close tags must match, see Strict.
+func (d *Decoder) isStrict() bool {
+	return d.Strict || d.Validate
+}
+
+// pushSpace records whether the element that start opens preserves whitespace, which is inherited
+// from its parent unless start has an `xml:space` attribute.
+func (d *Decoder) pushSpace(start *StartTag) {
+	preserve := d.preserveSpace()
+	for _, a := range start.Attr {
+		if a.Name.space != "xml" || a.Name.local != "space" {
+			continue
+		}
+		switch a.Value {
+		case "preserve":
+			preserve = true
+		case "default":
+			preserve = false
+		}
+	}
+	d.spaces = append(d.spaces, preserve)
+}
+
+// preserveSpace returns whether CharData at the current position must keep its whitespace.
+func (d *Decoder) preserveSpace() bool {
+	return len(d.spaces) > 0 && d.spaces[len(d.spaces)-1]
+}
+
+// InputPos returns the current row and column of the Decoder in the input, which is usually where
+// the last token returned by Token ends.
+func (d *Decoder) InputPos() Position {
+	return Position{Row: d.row + 1, Col: d.col}
+}
+
+// popTag removes the last open element from the stack if it matches name.
+func (d *Decoder) popTag(name *Name) error {
+	if len(d.stack) == 0 {
+		return &TagMismatchError{Close: name, ClosePos: d.tagPos}
+	}
+	top := d.stack[len(d.stack)-1]
+	if !sameName(top.name, name) && !(d.HTMLMode && sameHTMLName(top.name, name)) {
+		return &TagMismatchError{Start: top.name, StartPos: top.pos, Close: name, ClosePos: d.tagPos}
+	}
+	d.stack = d.stack[:len(d.stack)-1]
+	return nil
+}
+
 func (d *Decoder) token() (Token, error) {
+	if !d.detected {
+		d.detectEncoding()
+	}
+	d.tokenStart = d.offset
 	if d.startedTag {
 		d.startedTag = false
 		return d.angleStart()
@@ -117,12 +502,16 @@ func (d *Decoder) token() (Token, error) {
 		d.selfClosingTag = nil
 		return &d.closeTagBuf, nil
 	}
+	if d.rawText != nil {
+		return d.readRawText()
+	}
 	r, err := d.next()
 	if err != nil {
 		return nil, err
 	}
 	switch {
 	case r == '<':
+		d.tagPos = Position{Row: d.row + 1, Col: d.col}
 		// StartElement
 		// EndElement
 		// Comment
@@ -133,6 +522,7 @@ func (d *Decoder) token() (Token, error) {
 		return nil, unexpectedChar(r)
 	}
 	//CharData
+	d.textPos = Position{Row: d.row + 1, Col: d.col}
 	return d.charData(r)
 }
 
@@ -143,7 +533,37 @@ func unexpectedChar(r rune) error {
 
 // next reads the next rune and updates col/row positions for better error messaging.
 func (d *Decoder) next() (rune, error) {
-	r, _, err := d.r.ReadRune()
+	r, size, err := d.r.ReadRune()
+	if err != nil {
+		return r, err
+	}
+	switch {
+	case r == '\r':
+		// Line endings are normalized, "\r\n" and a lone "\r" are read as "\n". XML 1.1 also
+		// normalizes "\r" followed by NEL.
+		if b, _ := d.r.Peek(2); len(b) > 0 && b[0] == '\n' {
+			size++
+			d.r.Discard(1)
+		} else if d.isXML11() && bytes.HasPrefix(b, nel) {
+			size += len(nel)
+			d.r.Discard(len(nel))
+		}
+		r = '\n'
+	case (r == 0x85 || r == 0x2028) && d.isXML11():
+		// XML 1.1 also treats NEL and LINE SEPARATOR as line endings.
+		r = '\n'
+	}
+	d.offset += int64(size)
+	// The input is buffered, so this counts the bytes read ahead of r too.
+	if d.MaxInputBytes > 0 && d.input.n > d.MaxInputBytes {
+		return 0, fmt.Errorf("%w, more than %d bytes", InputTooLarge, d.MaxInputBytes)
+	}
+	if d.MaxTokenBytes > 0 && d.offset-d.tokenStart > int64(d.MaxTokenBytes) {
+		return 0, fmt.Errorf("%w, more than %d bytes", TokenTooLarge, d.MaxTokenBytes)
+	}
+	if d.saved != nil {
+		d.saved.WriteRune(r)
+	}
 	if r == '\n' {
 		d.col = 0
 		d.row++
@@ -153,6 +573,14 @@ func (d *Decoder) next() (rune, error) {
 	return r, err
 }
 
+// nel is the UTF-8 encoding of the NEL line ending in XML 1.1.
+var nel = []byte{0xC2, 0x85}
+
+// isXML11 reports whether the input is decoded with the XML 1.1 rules, see XML11.
+func (d *Decoder) isXML11() bool {
+	return d.XML11 || d.declared11
+}
+
 // checkUnexpectedEOF is a helper function to catch an EOF and transform it to UnexpectedEOF
 // when it happens mid-way during parsing.
 func checkUnexpectedEOF(err error) error {
@@ -162,41 +590,46 @@ func checkUnexpectedEOF(err error) error {
 	return err
 }
 
-func (d *Decoder) charData(start rune) (Token, error) {
+func (d *Decoder) charData(r rune) (Token, error) {
 	d.buf.Reset()
-	// Normalize whitespace
-	// TODO: Add an option on Decoder to not-normalize whitespace
-	space := unicode.IsSpace(start)
-	if space {
-		start = ' '
-	}
-	d.buf.WriteRune(start)
+	d.charDataBuf.CDATA = false
+	preserve := d.PreserveWhitespace || d.preserveSpace()
+	var space bool
 	for {
-		r, err := d.next()
-		if err != nil {
+		switch {
+		case r == '>':
+			return nil, fmt.Errorf("%w on chardata", unexpectedChar(r))
+		case r == '&' && !d.RawEntities:
+			if err := d.readEntity(); err != nil {
+				return nil, fmt.Errorf("%w on chardata", err)
+			}
+			space = false
+		// Normalize whitespace
+		case unicode.IsSpace(r) && !preserve:
+			if !space {
+				space = true
+				d.buf.WriteByte(' ')
+			}
+		default:
+			space = false
+			d.buf.WriteRune(r)
+		}
+
+		var err error
+		r, err = d.next()
+		if errors.Is(err, io.EOF) {
 			d.charDataBuf.Data = d.buf.Bytes()
 			return &d.charDataBuf, nil
 		}
+		if err != nil {
+			return nil, err
+		}
 		if r == '<' {
+			d.tagPos = Position{Row: d.row + 1, Col: d.col}
 			d.startedTag = true
 			d.charDataBuf.Data = d.buf.Bytes()
 			return &d.charDataBuf, nil
 		}
-		if r == '>' {
-			return nil, fmt.Errorf("%w on chardata", unexpectedChar(r))
-		}
-		// Normalize whitespace
-		// TODO: Add an option on Decoder to not-normalize whitespace
-		if unicode.IsSpace(r) {
-			if space {
-				continue
-			}
-			space = true
-			r = ' '
-		} else {
-			space = false
-		}
-		d.buf.WriteRune(r)
 	}
 }
 
@@ -209,7 +642,7 @@ func (d *Decoder) angleStart() (Token, error) {
 		return nil, checkUnexpectedEOF(err)
 	}
 	switch {
-	case isASCIILetter(r):
+	case isNameStartChar(r):
 		// StartElement
 		d.buf.Reset()
 		d.buf.WriteRune(r)
@@ -219,6 +652,7 @@ func (d *Decoder) angleStart() (Token, error) {
 		return d.closeTag()
 	case r == '!':
 		// Comment
+		// CDATA
 		// Directive
 		d.buf.Reset()
 
@@ -226,6 +660,12 @@ func (d *Decoder) angleStart() (Token, error) {
 		if err != nil {
 			return nil, checkUnexpectedEOF(err)
 		}
+		if r == '[' {
+			return d.cdata()
+		}
+		if r == 'D' && (d.ReadDTD || d.Validate) {
+			return d.doctype()
+		}
 		if r != '-' {
 			return d.directive(r)
 		}
@@ -255,26 +695,26 @@ func (d *Decoder) startTag() (Token, error) {
 
 	d.startTagBuf.Name = name
 	if last == '>' {
+		d.startTagBuf.Attr = nil
 		return &d.startTagBuf, nil
 	}
 
 	// attributes
 	d.attrs.reset()
+	if last == '/' {
+		return d.endSelfClosingTag()
+	}
+	// next holds the first letter of an attribute that was read after one without value.
+	var next rune
 	for {
-		last, err = d.consumeSpace()
-		if err != nil {
+		if next != 0 {
+			last, next = next, 0
+		} else if last, err = d.consumeSpace(); err != nil {
 			return nil, fmt.Errorf("%w, expected attribute identifier", err)
 		}
 
 		if last == '/' {
-			d.selfClosingTag = d.startTagBuf.Name
-			last, err = d.next()
-			if err != nil {
-				return nil, fmt.Errorf("%w, expected '>' for self-close tag", err)
-			}
-			if last != '>' {
-				return nil, fmt.Errorf("%w, expected '>' for self-close tag", unexpectedChar(last))
-			}
+			return d.endSelfClosingTag()
 		}
 
 		// See if there are no more attributes
@@ -282,7 +722,7 @@ func (d *Decoder) startTag() (Token, error) {
 		case last == '>':
 			d.startTagBuf.Attr = d.attrs.get()
 			return &d.startTagBuf, nil
-		case !isASCIILetter(last):
+		case !isNameStartChar(last):
 			return nil, fmt.Errorf("%w on tag <%s>", unexpectedChar(last), d.startTagBuf.Name)
 		}
 
@@ -300,19 +740,26 @@ func (d *Decoder) startTag() (Token, error) {
 			}
 		}
 
-		// attribute without value looks like <foo name> or <foo name bar="baz">
+		// attribute without value looks like <foo name>, <foo name/> or <foo name bar="baz">
 		attr := Attr{Name: name}
-		if last == '=' || last == '>' || isASCIILetter(last) {
+		if last == '=' || last == '>' || last == '/' || isNameStartChar(last) {
 			d.attrs.add(&attr)
 		} else {
 			return nil, fmt.Errorf("%w for attribute %s on tag <%s>", unexpectedChar(last), name, d.startTagBuf.Name)
 		}
+		if d.MaxAttrs > 0 && d.attrs.pos > d.MaxAttrs {
+			return nil, fmt.Errorf("%w, more than %d on tag <%s>", TooManyAttrs, d.MaxAttrs, d.startTagBuf.Name)
+		}
 		if last == '>' {
 			d.startTagBuf.Attr = d.attrs.get()
 			return &d.startTagBuf, nil
 		}
+		if last == '/' {
+			return d.endSelfClosingTag()
+		}
 
 		if last != '=' {
+			next = last
 			continue
 		}
 
@@ -321,7 +768,17 @@ func (d *Decoder) startTag() (Token, error) {
 		if err != nil {
 			return nil, fmt.Errorf("%w after attribute %s on tag <%s>", err, name, d.startTagBuf.Name)
 		}
-		// TODO: support naked attribute values, i.e. without quotes
+		if last != '"' && last != '\'' && d.HTMLMode {
+			attr.Value, last, err = d.readUnquoted(last)
+			if err != nil {
+				return nil, fmt.Errorf("%w reading attribute %s value on tag <%s>", err, name, d.startTagBuf.Name)
+			}
+			if last == '>' {
+				d.startTagBuf.Attr = d.attrs.get()
+				return &d.startTagBuf, nil
+			}
+			continue
+		}
 		if last != '"' && last != '\'' {
 			return nil, fmt.Errorf("%w, expected value for attribute %s on tag <%s>", unexpectedChar(last), name, d.startTagBuf.Name)
 		}
@@ -333,10 +790,26 @@ func (d *Decoder) startTag() (Token, error) {
 	}
 }
 
+// endSelfClosingTag finishes a token like <foo/> or <foo bar="baz" /> after the slash (/) has been
+// consumed, a CloseTag token will be emitted next.
+func (d *Decoder) endSelfClosingTag() (Token, error) {
+	d.selfClosingTag = d.startTagBuf.Name
+	last, err := d.next()
+	if err != nil {
+		return nil, fmt.Errorf("%w, expected '>' for self-close tag", checkUnexpectedEOF(err))
+	}
+	if last != '>' {
+		return nil, fmt.Errorf("%w, expected '>' for self-close tag", unexpectedChar(last))
+	}
+	d.startTagBuf.Attr = d.attrs.get()
+	return &d.startTagBuf, nil
+}
+
 // readString reads a string ending in a given quote rune, assumes initial quote has
 // already been consumed.
 //
-// It doesn't support escaping with backslash or HTML entities like &quot;
+// It doesn't support escaping with backslash, but decodes entities like &quot; unless RawEntities
+// is set. Tabs and line endings are read as spaces like the XML spec requires.
 func (d *Decoder) readString(quote rune) (string, error) {
 	for {
 		r, err := d.next()
@@ -346,6 +819,17 @@ func (d *Decoder) readString(quote rune) (string, error) {
 		if r == quote {
 			return d.buf.String(), nil
 		}
+		if r == '&' && !d.RawEntities {
+			if err := d.readEntity(); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if r == '\t' || r == '\n' {
+			// Attribute values are normalized, tabs and line endings are read as spaces. Character
+			// references like `&#10;` are kept.
+			r = ' '
+		}
 		d.buf.WriteRune(r)
 	}
 }
@@ -356,7 +840,7 @@ func (d *Decoder) closeTag() (Token, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%w, expected closing tag", err)
 	}
-	if !isASCIILetter(last) {
+	if !isNameStartChar(last) {
 		return nil, fmt.Errorf("%w, expected closing tag", unexpectedChar(last))
 	}
 	d.buf.Reset()
@@ -406,24 +890,80 @@ func (d *Decoder) comment() (Token, error) {
 	}
 }
 
-// procInst processes a token like: <?  ?>
+// procInst processes a token like: <?target inst?>
 func (d *Decoder) procInst() (Token, error) {
-	// TODO: Read contents, but disabled by default, only enabled with an option.
-	// TODO: Only allow at the beginning of the file
-	var questionMark bool
-	for {
-		r, err := d.next()
-		if err != nil {
+	// Whitespace before the target isn't valid XML, but it's tolerated like in closing tags.
+	r, err := d.consumeSpace()
+	if err != nil {
+		return nil, fmt.Errorf("%w, expected proc inst target", err)
+	}
+	d.buf.Reset()
+	if !isNameStartChar(r) {
+		return nil, fmt.Errorf("%w, expected proc inst target", unexpectedChar(r))
+	}
+	for isNameChar(r) || r == ':' {
+		d.buf.WriteRune(r)
+		if r, err = d.next(); err != nil {
 			return nil, checkUnexpectedEOF(err)
 		}
-		if r == '>' {
-			if questionMark {
-				return &d.procInstBuf, nil
-			}
-			return nil, errors.New("proc inst closed too early, must end in '?>'")
+	}
+	target := d.internName(d.buf.Bytes()).String()
+	if err := d.checkNames(); err != nil {
+		return nil, err
+	}
+	isDecl := target == "xml"
+	if !isDecl && strings.EqualFold(target, "xml") {
+		return nil, fmt.Errorf("proc inst target %q is reserved", target)
+	}
+	if isDecl && d.tagPos != (Position{Row: 1, Col: 1}) {
+		return nil, fmt.Errorf("%w, it must be at the start of the document", InvalidDeclaration)
+	}
+
+	if r != '?' && !unicode.IsSpace(r) {
+		return nil, fmt.Errorf("%w reading proc inst target", unexpectedChar(r))
+	}
+
+	// Skip the whitespace between the target and the contents.
+	for unicode.IsSpace(r) {
+		if r, err = d.next(); err != nil {
+			return nil, checkUnexpectedEOF(err)
 		}
+	}
+	keep := d.ReadProcInst || isDecl
+	d.buf.Reset()
+	var questionMark bool
+	for r != '>' || !questionMark {
 		questionMark = r == '?'
+		if keep {
+			d.buf.WriteRune(r)
+		}
+		if r, err = d.next(); err != nil {
+			return nil, checkUnexpectedEOF(err)
+		}
+	}
+	var inst []byte
+	if keep {
+		// Drop the '?' before the final '>'.
+		inst = d.buf.Bytes()[:d.buf.Len()-1]
+	}
+
+	d.procInstBuf.Target = target
+	d.procInstBuf.Inst = nil
+	d.procInstBuf.Decl = nil
+	if isDecl {
+		if err := parseDeclaration(&d.declBuf, inst); err != nil {
+			return nil, err
+		}
+		d.procInstBuf.Decl = &d.declBuf
+		d.declared11 = d.declBuf.Version == "1.1"
+		if err := d.switchEncoding(d.declBuf.Encoding); err != nil {
+			return nil, err
+		}
 	}
+	if d.ReadProcInst {
+		d.procInstBuf.Inst = inst
+	}
+	return &d.procInstBuf, nil
 }
 
 // directive processes a token like: <!  > or <! [] > or <! {} >
@@ -469,6 +1009,38 @@ func (d *Decoder) directive(last rune) (Token, error) {
 	}
 }
 
+// cdataStart is the rest of the opening of a CDATA section after `<![`.
+const cdataStart = "CDATA["
+
+// cdata processes a token like <![CDATA[ foo ]]> after the `<![` has been consumed. The contents
+// are returned verbatim as CharData, without decoding entities or normalizing whitespace.
+func (d *Decoder) cdata() (Token, error) {
+	for _, want := range cdataStart {
+		r, err := d.next()
+		if err != nil {
+			return nil, checkUnexpectedEOF(err)
+		}
+		if r != want {
+			return nil, fmt.Errorf("%w, expected '<![CDATA['", unexpectedChar(r))
+		}
+	}
+	for {
+		r, err := d.next()
+		if err != nil {
+			return nil, fmt.Errorf("%w, expected ']]>' for CDATA", checkUnexpectedEOF(err))
+		}
+		if r == '>' && bytes.HasSuffix(d.buf.Bytes(), cdataEnd) {
+			d.charDataBuf.Data = d.buf.Bytes()[:d.buf.Len()-len(cdataEnd)]
+			d.charDataBuf.CDATA = true
+			return &d.charDataBuf, nil
+		}
+		d.buf.WriteRune(r)
+	}
+}
+
+// cdataEnd is the end of a CDATA section, without the final `>`.
+var cdataEnd = []byte("]]")
+
 // consume reads out all runes matching the function and return the last non-space rune
 func (d *Decoder) consume(match func(rune) bool, read bool) (rune, error) {
 	for {
@@ -495,9 +1067,10 @@ func (d *Decoder) consumeSpace() (rune, error) {
 // the distinction between attribute and tag name is important because attributes can be
 // follwed up by an equals sign (=) character.
 func (d *Decoder) readIdentifier(isAttribute bool) (*Name, rune, error) {
-	var prev, r rune
+	var r rune
 	var err error
-	var foundNS bool
+	// afterNS is set right after the namespace colon, where the local name must start.
+	var foundNS, afterNS bool
 loop:
 	for {
 		r, err = d.next()
@@ -506,50 +1079,89 @@ loop:
 		}
 		switch {
 		case r == ':' && !foundNS:
-			foundNS = true
-			fallthrough
-		case isIdentifierChar(r):
+			foundNS, afterNS = true, true
 			d.buf.WriteRune(r)
-		case unicode.IsSpace(r), (r == '=' && isAttribute):
-			last := prev
-			if !isASCIILetter(last) {
-				return nil, 0, fmt.Errorf("%w reading identifier", unexpectedChar(last))
-			}
-			break loop
-		case r == '>':
+		case afterNS && isNameStartChar(r), !afterNS && isNameChar(r):
+			afterNS = false
+			d.buf.WriteRune(r)
+		case unicode.IsSpace(r), (r == '=' && isAttribute), r == '>', r == '/':
 			break loop
 		default:
 			return nil, 0, fmt.Errorf("%w reading identifier", unexpectedChar(r))
 		}
-		prev = r
 	}
-
-	// Somehow implementing a []rune buffer is worse performing than casting buf.String()
-	runes := []rune(d.buf.String())
-	name, ok := d.names.Get(runes)
-	if ok {
-		return name.(*Name), r, nil
+	if afterNS {
+		// We only validate the second part because the first part can't be empty for the code to
+		// enter this function.
+		return nil, 0, fmt.Errorf("%w reading identifier", unexpectedChar(':'))
 	}
 
-	if foundNS {
-		parts := strings.SplitN(d.buf.String(), ":", 2)
-		if len(parts[1]) == 0 {
-			// We only validate the second part because the first part can't be empty for the code
-			// to enter this function.
-			return nil, 0, fmt.Errorf("%w reading identifier", unexpectedChar(':'))
-		}
-		name = &Name{space: parts[0], local: parts[1]}
-	} else {
-		name = &Name{local: d.buf.String()}
+	// The table only copies the identifier when it's a new name.
+	name := d.internName(d.buf.Bytes())
+	if err := d.checkNames(); err != nil {
+		return nil, 0, err
 	}
-	d.names.Put(runes, name)
-	return name.(*Name), r, nil
+	return name, r, nil
 }
 
-func isIdentifierChar(r rune) bool {
-	return isASCIILetter(r) || r == '-' || r == '_'
+// checkNames fails once more names were interned than MaxNames allows.
+func (d *Decoder) checkNames() error {
+	if d.MaxNames > 0 && d.nameCount > d.MaxNames {
+		return fmt.Errorf("%w, more than %d", TooManyNames, d.MaxNames)
+	}
+	return nil
 }
 
 func isASCIILetter(r rune) bool {
 	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
 }
+
+// isNameStartChar reports whether r can start a tag or attribute name, or the local part after the
+// namespace colon, following the NameStartChar production of XML 1.0 fifth edition except for the
+// colon, which is handled as the namespace separator.
+func isNameStartChar(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isASCIILetter(r) || r == '_'
+	}
+	return unicode.Is(nameStartTable, r)
+}
+
+// isNameChar reports whether r can appear after the first character of a name, following the
+// NameChar production of XML 1.0 fifth edition except for the colon.
+func isNameChar(r rune) bool {
+	if r < utf8.RuneSelf {
+		return isASCIILetter(r) || r == '_' || r == '-' || r == '.' || (r >= '0' && r <= '9')
+	}
+	return unicode.Is(nameStartTable, r) || unicode.Is(nameTable, r)
+}
+
+// nameStartTable holds the non-ASCII ranges of NameStartChar, XML 1.1 defines the same ranges.
+var nameStartTable = &unicode.RangeTable{
+	LatinOffset: 2,
+	R16: []unicode.Range16{
+		{Lo: 0xC0, Hi: 0xD6, Stride: 1},
+		{Lo: 0xD8, Hi: 0xF6, Stride: 1},
+		{Lo: 0xF8, Hi: 0x2FF, Stride: 1},
+		{Lo: 0x370, Hi: 0x37D, Stride: 1},
+		{Lo: 0x37F, Hi: 0x1FFF, Stride: 1},
+		{Lo: 0x200C, Hi: 0x200D, Stride: 1},
+		{Lo: 0x2070, Hi: 0x218F, Stride: 1},
+		{Lo: 0x2C00, Hi: 0x2FEF, Stride: 1},
+		{Lo: 0x3001, Hi: 0xD7FF, Stride: 1},
+		{Lo: 0xF900, Hi: 0xFDCF, Stride: 1},
+		{Lo: 0xFDF0, Hi: 0xFFFD, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x10000, Hi: 0xEFFFF, Stride: 1},
+	},
+}
+
+// nameTable holds the non-ASCII ranges that NameChar adds to NameStartChar.
+var nameTable = &unicode.RangeTable{
+	LatinOffset: 1,
+	R16: []unicode.Range16{
+		{Lo: 0xB7, Hi: 0xB7, Stride: 1},
+		{Lo: 0x300, Hi: 0x36F, Stride: 1},
+		{Lo: 0x203F, Hi: 0x2040, Stride: 1},
+	},
+}
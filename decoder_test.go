@@ -15,6 +15,7 @@
 package xml
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 	"strings"
@@ -49,7 +50,7 @@ func TestToken(t *testing.T) {
 		&Directive{},
 		&CharData{Data: []byte(" ")},
 		&Directive{},
-		&ProcInst{},
+		&ProcInst{Target: "whatever"},
 		&CharData{Data: []byte(" qwe 123 . ")},
 		&CloseTag{&Name{local: "foo", space: "lol"}},
 		&StartTag{Name: &Name{local: "yay"}, Attr: []*Attr{{&Name{local: "attr"}, "123"}}},
@@ -134,6 +135,227 @@ func TestTokenOptionalDirective(t *testing.T) {
 	}
 }
 
+func TestTokenCDATA(t *testing.T) {
+	const input = `<a> x  <![CDATA[ 1 < 2 &amp;  ]] ]>
+	]]>  y </a>`
+	d := NewDecoder(strings.NewReader(input))
+
+	want := []Token{
+		&StartTag{Name: &Name{local: "a"}},
+		&CharData{Data: []byte(" x ")},
+		&CharData{Data: []byte(" 1 < 2 &amp;  ]] ]>\n\t"), CDATA: true},
+		&CharData{Data: []byte(" y ")},
+		&CloseTag{&Name{local: "a"}},
+	}
+
+	var got []Token
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		got = append(got, tok.Copy())
+	}
+
+	opts := cmp.Options{
+		cmp.AllowUnexported(Name{}),
+		cmp.Transformer("byteToString", func(in []byte) string { return string(in) }),
+	}
+
+	if diff := cmp.Diff(want, got, opts); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+}
+
+func TestTokenWhitespace(t *testing.T) {
+	const input = "<a> 1 \n 2 <b xml:space=\"preserve\">\t3\n<c> 4  5 </c>" +
+		"<c xml:space=\"default\"> 6  7 </c></b><b/> 8 \t 9 </a>"
+	testCases := []struct {
+		desc     string
+		preserve bool
+		want     []string
+	}{
+		{
+			desc: "normalized",
+			want: []string{" 1 2 ", "\t3\n", " 4  5 ", " 6 7 ", " 8 9 "},
+		},
+		{
+			desc:     "preserved",
+			preserve: true,
+			want:     []string{" 1 \n 2 ", "\t3\n", " 4  5 ", " 6  7 ", " 8 \t 9 "},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(input))
+			d.PreserveWhitespace = tc.preserve
+			var got []string
+			for {
+				tok, err := d.Token()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c, ok := tok.(*CharData); ok {
+					got = append(got, string(c.Data))
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Error("CharData diff (-want +got)\n", diff)
+			}
+		})
+	}
+}
+
+func TestTokenOptionalProcInst(t *testing.T) {
+	const input = `<?xml-stylesheet  type="text/xsl"
+	href="style.xsl"?>`
+	testCases := []struct {
+		desc         string
+		readProcInst bool
+		want         string
+	}{
+		{desc: "enabled", readProcInst: true, want: "type=\"text/xsl\"\n\thref=\"style.xsl\""},
+		{desc: "disabled", readProcInst: false, want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(input))
+			d.ReadProcInst = tc.readProcInst
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := tok.(*ProcInst)
+			if p.Target != "xml-stylesheet" {
+				t.Errorf("procInst.Target '%s', want 'xml-stylesheet'", p.Target)
+			}
+			if got := string(p.Inst); got != tc.want {
+				t.Errorf("procInst.Inst '%s', want '%s'", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenNames(t *testing.T) {
+	const input = `<h1 data-v2="1" x.y='2' _z="3"><a.b/><título ns:só-ç·1=""/></h1 >`
+	d := NewDecoder(strings.NewReader(input))
+
+	want := []Token{
+		&StartTag{Name: &Name{local: "h1"}, Attr: []*Attr{
+			{&Name{local: "data-v2"}, "1"},
+			{&Name{local: "x.y"}, "2"},
+			{&Name{local: "_z"}, "3"},
+		}},
+		&StartTag{Name: &Name{local: "a.b"}},
+		&CloseTag{&Name{local: "a.b"}},
+		&StartTag{Name: &Name{local: "título"}, Attr: []*Attr{{&Name{space: "ns", local: "só-ç·1"}, ""}}},
+		&CloseTag{&Name{local: "título"}},
+		&CloseTag{&Name{local: "h1"}},
+	}
+
+	var got []Token
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		got = append(got, tok.Copy())
+	}
+
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+}
+
+func TestTokenLineEndings(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		input    string
+		xml11    bool
+		wantAttr string
+		wantText string
+		wantPos  Position
+	}{
+		{
+			desc:     "unix",
+			input:    "<a b='x\ty\nz&#9;&#10;'>1\n2\n\n3</a>",
+			wantAttr: "x y z\t\n",
+			wantText: "1\n2\n\n3",
+			wantPos:  Position{Row: 5, Col: 5},
+		},
+		{
+			desc:     "windows",
+			input:    "<a b='x\ty\r\nz&#9;&#10;'>1\r\n2\r\n\r\n3</a>",
+			wantAttr: "x y z\t\n",
+			wantText: "1\n2\n\n3",
+			wantPos:  Position{Row: 5, Col: 5},
+		},
+		{
+			desc:     "classic mac",
+			input:    "<a b='x\ty\rz&#9;&#10;'>1\r2\r\r3</a>",
+			wantAttr: "x y z\t\n",
+			wantText: "1\n2\n\n3",
+			wantPos:  Position{Row: 5, Col: 5},
+		},
+		{
+			desc:     "xml 1.0 keeps NEL",
+			input:    "<a b='x\u0085y'>1\u20282\r\u00853</a>",
+			wantAttr: "x\u0085y",
+			wantText: "1\u20282\n\u00853",
+			wantPos:  Position{Row: 2, Col: 6},
+		},
+		{
+			desc:     "xml 1.1",
+			input:    "<a b='x\u0085y'>1\u20282\r\u00853</a>",
+			xml11:    true,
+			wantAttr: "x y",
+			wantText: "1\n2\n3",
+			wantPos:  Position{Row: 4, Col: 5},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.PreserveWhitespace = true
+			d.XML11 = tc.xml11
+			var attr, text string
+			for {
+				tok, err := d.Token()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				switch tok := tok.(type) {
+				case *StartTag:
+					attr = tok.Attr[0].Value
+				case *CharData:
+					text += string(tok.Data)
+				}
+			}
+			if attr != tc.wantAttr {
+				t.Errorf("attribute value %q, want %q", attr, tc.wantAttr)
+			}
+			if text != tc.wantText {
+				t.Errorf("CharData %q, want %q", text, tc.wantText)
+			}
+			if got := d.InputPos(); got != tc.wantPos {
+				t.Errorf("InputPos() %s, want %s", got, tc.wantPos)
+			}
+		})
+	}
+}
+
 func TestTokenErrors(t *testing.T) {
 	testCases := []struct {
 		desc  string
@@ -143,10 +365,21 @@ func TestTokenErrors(t *testing.T) {
 		{"start colon", "<:foo>", "unexpected char ':'"},
 		{"end colon", "<foo:>", "unexpected char ':'"},
 		{"multi colon", "<f:o:o>", "unexpected char ':'"},
+		{"start digit", "<1a>", "unexpected char '1'"},
+		{"start dot", "<.a>", "unexpected char '.'"},
+		{"local start digit", "<a:1>", "unexpected char '1' reading identifier"},
+		{"attribute start hyphen", "<a -b='1'>", "unexpected char '-' on tag <a>"},
+		{"symbol", "<a$b>", "unexpected char '$' reading identifier"},
 		{"bad comment open", "<!- -->", "unexpected char ' ', expected '<--'"},
 		{"bad comment close", "<!-- ->", "comment closed too early, must end in '-->'"},
 		{"early EOF at tag", "<asd", "unexpected EOF, expected tag identifier at"},
 		{"early EOF at comment", "<!-- asd --", "unexpected EOF at"},
+		{"bad CDATA open", "<![CDATX[ foo ]]>", "unexpected char 'X', expected '<![CDATA['"},
+		{"proc inst missing target", "<??>", "unexpected char '?', expected proc inst target"},
+		{"proc inst bad target", "<?foo\"bar\"?>", "unexpected char '\"' reading proc inst target"},
+		{"proc inst reserved target", "<?XML version='1.0'?>", `proc inst target "XML" is reserved`},
+		{"early EOF at proc inst", "<?foo bar?", "unexpected EOF at"},
+		{"early EOF at CDATA", "<![CDATA[ foo ]]", "unexpected EOF, expected ']]>' for CDATA at"},
 	}
 
 	for _, tc := range testCases {
@@ -191,3 +424,274 @@ func TestErrorLineNumber(t *testing.T) {
 		t.Fatalf("err: '%s' want '%s'", err, want)
 	}
 }
+
+func TestTokenSelfClosing(t *testing.T) {
+	const input = `<a/><b />`
+	d := NewDecoder(strings.NewReader(input))
+
+	want := []Token{
+		&StartTag{Name: &Name{local: "a"}},
+		&CloseTag{&Name{local: "a"}},
+		&StartTag{Name: &Name{local: "b"}},
+		&CloseTag{&Name{local: "b"}},
+	}
+
+	var got []Token
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		got = append(got, tok.Copy())
+	}
+
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+}
+
+func TestTokenReusedBuffers(t *testing.T) {
+	// <a> is a prefix of the previously interned <ab> and must not reuse the attributes of <ab>.
+	const input = `<ab x="1"><a>`
+	d := NewDecoder(strings.NewReader(input))
+
+	want := []Token{
+		&StartTag{Name: &Name{local: "ab"}, Attr: []*Attr{{&Name{local: "x"}, "1"}}},
+		&StartTag{Name: &Name{local: "a"}},
+	}
+
+	var got []Token
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		got = append(got, tok.Copy())
+	}
+
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+}
+
+func TestTokenStrict(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  error
+	}{
+		{
+			desc:  "valid",
+			input: "<a>\n  <b/><c:d>text</c:d>\n</a>",
+		},
+		{
+			desc:  "mismatch",
+			input: "<a>\n  <foo>\n  </bar>\n</a>",
+			want: &TagMismatchError{
+				Start:    &Name{local: "foo"},
+				StartPos: Position{Row: 2, Col: 3},
+				Close:    &Name{local: "bar"},
+				ClosePos: Position{Row: 3, Col: 3},
+			},
+		},
+		{
+			desc:  "namespace mismatch",
+			input: "<x:a></y:a>",
+			want: &TagMismatchError{
+				Start:    &Name{space: "x", local: "a"},
+				StartPos: Position{Row: 1, Col: 1},
+				Close:    &Name{space: "y", local: "a"},
+				ClosePos: Position{Row: 1, Col: 6},
+			},
+		},
+		{
+			desc:  "close without start",
+			input: "<a></a></b>",
+			want: &TagMismatchError{
+				Close:    &Name{local: "b"},
+				ClosePos: Position{Row: 1, Col: 8},
+			},
+		},
+		{
+			desc:  "unclosed at EOF",
+			input: "<a>\n <b>text</b>\n <c>",
+			want: &UnclosedTagError{
+				Name: &Name{local: "c"},
+				Pos:  Position{Row: 3, Col: 2},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.Strict = true
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			if diff := cmp.Diff(tc.want, err, cmp.AllowUnexported(Name{})); diff != "" {
+				t.Errorf("Token error diff (-want +got)\n%s\n%v", diff, err)
+			}
+		})
+	}
+}
+
+func TestTokenStrictErrorMessages(t *testing.T) {
+	d := NewDecoder(strings.NewReader("<a>\n<b></a>"))
+	d.Strict = true
+	var err error
+	for err == nil {
+		_, err = d.Token()
+	}
+	const want = "close tag </a> at row: 2 col: 4 does not match start tag <b> at row: 2 col: 1"
+	if err.Error() != want {
+		t.Fatalf("err: '%s' want '%s'", err, want)
+	}
+}
+
+func TestTokenLimits(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		input   string
+		set     func(d *Decoder)
+		wantErr error
+	}{
+		{
+			desc:    "token bytes",
+			input:   "<a>" + strings.Repeat("x", 100) + "</a>",
+			set:     func(d *Decoder) { d.MaxTokenBytes = 99 },
+			wantErr: TokenTooLarge,
+		},
+		{
+			desc:    "token bytes in comment",
+			input:   "<!--" + strings.Repeat("x", 100) + "-->",
+			set:     func(d *Decoder) { d.MaxTokenBytes = 99 },
+			wantErr: TokenTooLarge,
+		},
+		{
+			desc:    "token bytes in attribute",
+			input:   `<a b="` + strings.Repeat("x", 100) + `"/>`,
+			set:     func(d *Decoder) { d.MaxTokenBytes = 99 },
+			wantErr: TokenTooLarge,
+		},
+		{
+			desc:  "token bytes fit",
+			input: "<a>" + strings.Repeat("x", 100) + "</a>",
+			set:   func(d *Decoder) { d.MaxTokenBytes = 101 },
+		},
+		{
+			desc:    "attributes",
+			input:   `<a b="1" c d="3"/>`,
+			set:     func(d *Decoder) { d.MaxAttrs = 2 },
+			wantErr: TooManyAttrs,
+		},
+		{
+			desc:  "attributes fit",
+			input: `<a b="1" c d="3"/>`,
+			set:   func(d *Decoder) { d.MaxAttrs = 3 },
+		},
+		{
+			desc:    "depth",
+			input:   "<a><b><c><d/></c></b></a>",
+			set:     func(d *Decoder) { d.MaxDepth = 3 },
+			wantErr: TooDeep,
+		},
+		{
+			desc:    "depth after stray close tags",
+			input:   strings.Repeat("</x>", 100) + strings.Repeat("<a>", 100),
+			set:     func(d *Decoder) { d.MaxDepth = 10 },
+			wantErr: TooDeep,
+		},
+		{
+			desc:  "depth fits",
+			input: "<a><b><c/></b></a>",
+			set:   func(d *Decoder) { d.MaxDepth = 3 },
+		},
+		{
+			desc:    "input bytes",
+			input:   "<a>" + strings.Repeat("<b/>", 10) + "</a>",
+			set:     func(d *Decoder) { d.MaxInputBytes = 46 },
+			wantErr: InputTooLarge,
+		},
+		{
+			desc:  "input bytes fit",
+			input: "<a>" + strings.Repeat("<b/>", 10) + "</a>",
+			set:   func(d *Decoder) { d.MaxInputBytes = 47 },
+		},
+		{
+			// The limit counts the 96 bytes read rather than the 47 decoded into UTF-8.
+			desc:    "input bytes in UTF-16",
+			input:   string(encodeUTF16("<a>"+strings.Repeat("<b/>", 10)+"</a>", binary.LittleEndian, true)),
+			set:     func(d *Decoder) { d.MaxInputBytes = 95 },
+			wantErr: InputTooLarge,
+		},
+		{
+			desc:  "input bytes in UTF-16 fit",
+			input: string(encodeUTF16("<a>"+strings.Repeat("<b/>", 10)+"</a>", binary.LittleEndian, true)),
+			set:   func(d *Decoder) { d.MaxInputBytes = 96 },
+		},
+		{
+			desc:    "names",
+			input:   `<a b="1"><c/><a/><?d?></a>`,
+			set:     func(d *Decoder) { d.MaxNames = 3 },
+			wantErr: TooManyNames,
+		},
+		{
+			desc:  "names evicted from the table",
+			input: `<r><a/><b/><a/><b/></r>`,
+			set: func(d *Decoder) {
+				d.NameTable = NewLRUNameTable(2)
+				d.MaxNames = 3
+			},
+			wantErr: TooManyNames,
+		},
+		{
+			desc:  "names with namespaces",
+			input: `<x:a xmlns:x="urn:x"><x:b/></x:a>`,
+			set: func(d *Decoder) {
+				d.Namespaces = true
+				d.MaxNames = 5
+			},
+			wantErr: TooManyNames,
+		},
+		{
+			desc:  "names with namespaces fit",
+			input: `<x:a xmlns:x="urn:x"><x:b/></x:a>`,
+			set: func(d *Decoder) {
+				d.Namespaces = true
+				d.MaxNames = 6
+			},
+		},
+		{
+			desc:  "names fit",
+			input: `<a b="1"><c/><a/><c b=""/></a>`,
+			set:   func(d *Decoder) { d.MaxNames = 3 },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			tc.set(d)
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err: '%v' want '%v'", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,918 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DTD is the document type declaration like:
+//
+//    <!DOCTYPE messagebundle SYSTEM "messagebundle.dtd" [
+//      <!ELEMENT messagebundle (msg)*>
+//      <!ATTLIST msg id CDATA #REQUIRED>
+//    ]>
+//
+// Only the internal subset within the brackets is parsed, external DTDs are never fetched.
+type DTD struct {
+	// Name is the name of the root element.
+	Name string
+
+	// PublicID and SystemID are the external identifiers, they are empty when not declared.
+	PublicID string
+	SystemID string
+
+	// The declarations of the internal subset in the order they were written.
+	Elements  []*ElementDecl
+	Attlists  []*AttlistDecl
+	Entities  []*EntityDecl
+	Notations []*NotationDecl
+
+	// Lookup tables by name, only the first declaration of every name is kept like the spec requires.
+	elements map[string]*ElementDecl
+	attrs    map[string][]*AttDef
+	entities map[string]*EntityDecl
+	params   map[string]*EntityDecl
+
+	// Caches of the expanded replacement text of every entity, and the normalized default value of
+	// every attribute, see Decoder.writeDTDEntity and Decoder.addDefaultAttrs.
+	expanded map[*EntityDecl]string
+	defaults map[*AttDef]string
+}
+
+// Element returns the declaration of the element with the given name, or nil.
+func (dtd *DTD) Element(name string) *ElementDecl {
+	return dtd.elements[name]
+}
+
+// Attributes returns the attributes declared for the element with the given name by all of its
+// ATTLIST declarations.
+func (dtd *DTD) Attributes(element string) []*AttDef {
+	return dtd.attrs[element]
+}
+
+// Entity returns the general entity with the given name, or nil. Use the Entities slice to find
+// parameter entities.
+func (dtd *DTD) Entity(name string) *EntityDecl {
+	return dtd.entities[name]
+}
+
+// ContentKind is the kind of content allowed by an ElementDecl.
+type ContentKind int
+
+const (
+	// ContentEmpty is declared by EMPTY, the element has no content.
+	ContentEmpty ContentKind = iota
+	// ContentAny is declared by ANY, the element can contain anything.
+	ContentAny
+	// ContentMixed is declared like (#PCDATA|a|b)*, text mixed with the elements listed in Mixed.
+	ContentMixed
+	// ContentChildren is declared by a content model like (a,(b|c)*), only elements are allowed.
+	ContentChildren
+)
+
+// ElementDecl is an element type declaration like <!ELEMENT msg (#PCDATA|ph)*>
+type ElementDecl struct {
+	Name string
+	Kind ContentKind
+
+	// Mixed lists the element names allowed within the text for ContentMixed.
+	Mixed []string
+
+	// Model is the content model for ContentChildren.
+	Model *ContentParticle
+}
+
+// ContentParticle is an element name or a group within a content model, like `a?` or `(b|c)*`.
+type ContentParticle struct {
+	// Name is the element name, it is empty for groups.
+	Name string
+
+	// Choice is set for groups like (a|b), otherwise groups are sequences like (a,b).
+	Choice bool
+
+	// Children are the particles within the group.
+	Children []*ContentParticle
+
+	// Occurs is the suffix '?', '*', or '+', or 0 when the particle must appear exactly once.
+	Occurs rune
+}
+
+// String returns the content particle as written in the DTD, like "(a,(b|c)*)?".
+func (cp *ContentParticle) String() string {
+	var b strings.Builder
+	cp.write(&b)
+	return b.String()
+}
+
+func (cp *ContentParticle) write(b *strings.Builder) {
+	if cp.Name != "" {
+		b.WriteString(cp.Name)
+	} else {
+		sep := ","
+		if cp.Choice {
+			sep = "|"
+		}
+		b.WriteByte('(')
+		for i, c := range cp.Children {
+			if i > 0 {
+				b.WriteString(sep)
+			}
+			c.write(b)
+		}
+		b.WriteByte(')')
+	}
+	if cp.Occurs != 0 {
+		b.WriteRune(cp.Occurs)
+	}
+}
+
+// AttlistDecl is an attribute list declaration like <!ATTLIST msg id CDATA #REQUIRED>
+type AttlistDecl struct {
+	Element string
+	Attrs   []*AttDef
+}
+
+// AttDef is the definition of one attribute within an AttlistDecl.
+type AttDef struct {
+	Name string
+
+	// Type is one of "CDATA", "ID", "IDREF", "IDREFS", "ENTITY", "ENTITIES", "NMTOKEN", "NMTOKENS",
+	// "NOTATION", or "ENUMERATION" for a list of values like (yes|no).
+	Type string
+
+	// Enum lists the values allowed for the "NOTATION" and "ENUMERATION" types.
+	Enum []string
+
+	// Default is "#REQUIRED", "#IMPLIED", "#FIXED", or empty when the attribute has a default Value.
+	Default string
+
+	// Value is the default value as written in the DTD, for "#FIXED" attributes too.
+	Value string
+}
+
+// EntityDecl is an entity declaration like <!ENTITY name "value"> or <!ENTITY % name "value">
+type EntityDecl struct {
+	Name string
+
+	// Parameter is set for parameter entities like <!ENTITY % name "value">
+	Parameter bool
+
+	// Value is the replacement text for internal entities. Character references like `&#38;` are
+	// already decoded, and references to other entities like `&foo;` are kept.
+	Value string
+
+	// PublicID and SystemID are the identifiers of external entities, NData is the notation of
+	// unparsed entities.
+	PublicID string
+	SystemID string
+	NData    string
+}
+
+// External reports whether the entity is declared with an external identifier instead of a value.
+func (e *EntityDecl) External() bool {
+	return e.SystemID != ""
+}
+
+// NotationDecl is a notation declaration like <!NOTATION gif PUBLIC "image/gif">
+type NotationDecl struct {
+	Name     string
+	PublicID string
+	SystemID string
+}
+
+// maxDTDDepth bounds the nesting of parameter entities referenced within the internal subset.
+const maxDTDDepth = 16
+
+// doctype processes a token like <!DOCTYPE foo [ ... ]> when ReadDTD is set, after the `<!D` has
+// been consumed. The declaration is parsed into d.dtd and returned as a Directive.
+func (d *Decoder) doctype() (Token, error) {
+	if d.dtd != nil || d.depth > 0 {
+		return nil, fmt.Errorf("%w, DOCTYPE must appear once before the root element", InvalidDTD)
+	}
+	d.buf.WriteByte('D')
+
+	// Find the final '>', skipping quoted strings, comments, and processing instructions.
+	var quote rune
+	var subset bool
+	for {
+		r, err := d.next()
+		if err != nil {
+			return nil, fmt.Errorf("%w, expected '>' for DOCTYPE", checkUnexpectedEOF(err))
+		}
+		d.buf.WriteRune(r)
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"', r == '\'':
+			quote = r
+		case r == '[':
+			subset = true
+		case r == ']':
+			subset = false
+		case r == '-' && subset && bytes.HasSuffix(d.buf.Bytes(), []byte("<!--")):
+			if err := d.skipTo("-->"); err != nil {
+				return nil, err
+			}
+		case r == '?' && subset && bytes.HasSuffix(d.buf.Bytes(), []byte("<?")):
+			if err := d.skipTo("?>"); err != nil {
+				return nil, err
+			}
+		case r == '>' && !subset:
+			dtd, err := parseDTD(d.buf.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			d.dtd = dtd
+			if d.ReadDirective {
+				// Drop the final '>'.
+				d.directiveBuf.Data = d.buf.Bytes()[:d.buf.Len()-1]
+			}
+			return &d.directiveBuf, nil
+		}
+	}
+}
+
+// addDefaultAttrs adds to start the attributes it's missing that have a default value declared by
+// the DTD, like `<!ATTLIST msg xml:space (default|preserve) "preserve">`.
+func (d *Decoder) addDefaultAttrs(start *StartTag) error {
+	defs := d.dtd.attrs[start.Name.String()]
+	for _, def := range defs {
+		if def.Default != "" && def.Default != "#FIXED" {
+			continue
+		}
+		name := d.internName([]byte(def.Name))
+		if hasAttr(start.Attr, name) {
+			continue
+		}
+		value, err := d.defaultValue(def)
+		if err != nil {
+			return fmt.Errorf("%w in the default value of attribute %s on tag <%s>", err, name, start.Name)
+		}
+		start.Attr = append(start.Attr, &Attr{Name: name, Value: value})
+	}
+	return nil
+}
+
+// attrSpaces replaces the tabs and line endings in attribute values with spaces, line endings were
+// already normalized into "\n" while reading the DTD.
+var attrSpaces = strings.NewReplacer("\t", " ", "\n", " ")
+
+func hasAttr(attrs []*Attr, name *Name) bool {
+	for _, a := range attrs {
+		if sameName(a.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultValue returns the default value of def with its references expanded and whitespace
+// normalized like attribute values written in tags.
+func (d *Decoder) defaultValue(def *AttDef) (string, error) {
+	if value, ok := d.dtd.defaults[def]; ok {
+		return value, nil
+	}
+	var b strings.Builder
+	if err := d.dtd.expandText(&b, attrSpaces.Replace(def.Value), d.isXML11(), nil); err != nil {
+		return "", err
+	}
+	if d.dtd.defaults == nil {
+		d.dtd.defaults = make(map[*AttDef]string)
+	}
+	d.dtd.defaults[def] = b.String()
+	return b.String(), nil
+}
+
+// skipTo reads the input into d.buf up to and including end.
+func (d *Decoder) skipTo(end string) error {
+	for !bytes.HasSuffix(d.buf.Bytes(), []byte(end)) {
+		r, err := d.next()
+		if err != nil {
+			return fmt.Errorf("%w, expected %q in DOCTYPE", checkUnexpectedEOF(err), end)
+		}
+		d.buf.WriteRune(r)
+	}
+	return nil
+}
+
+// DTD returns the document type declaration read from the prolog, or nil if there was none or
+// ReadDTD is not set.
+func (d *Decoder) DTD() *DTD {
+	return d.dtd
+}
+
+// dtdParser parses the contents of a DOCTYPE directive.
+type dtdParser struct {
+	data []byte
+	pos  int
+	dtd  *DTD
+
+	// expanding holds the parameter entities being expanded, to catch recursive references.
+	expanding []string
+}
+
+// parseDTD parses a DOCTYPE directive like `DOCTYPE foo [ ... ]>` including the final '>'.
+func parseDTD(data []byte) (*DTD, error) {
+	p := &dtdParser{
+		data: data,
+		dtd: &DTD{
+			elements: make(map[string]*ElementDecl),
+			attrs:    make(map[string][]*AttDef),
+			entities: make(map[string]*EntityDecl),
+			params:   make(map[string]*EntityDecl),
+		},
+	}
+	if !p.consume("DOCTYPE") || !p.space() {
+		return nil, p.errorf("expected 'DOCTYPE '")
+	}
+	var err error
+	if p.dtd.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.space() && !p.peek('[') && !p.peek('>') {
+		if p.dtd.PublicID, p.dtd.SystemID, err = p.externalID(false); err != nil {
+			return nil, err
+		}
+		p.space()
+	}
+	if p.consume("[") {
+		if err := p.subset(true); err != nil {
+			return nil, err
+		}
+		p.space()
+	}
+	if !p.consume(">") || p.pos != len(p.data) {
+		return nil, p.errorf("expected '>' to end DOCTYPE")
+	}
+	return p.dtd, nil
+}
+
+// subset parses markup declarations up to the ']' ending the internal subset when inBrackets is
+// set, or to the end of the data for the value of a parameter entity.
+func (p *dtdParser) subset(inBrackets bool) error {
+	for {
+		p.space()
+		switch {
+		case p.pos == len(p.data):
+			if inBrackets {
+				return p.errorf("expected ']' to end the internal subset")
+			}
+			return nil
+		case inBrackets && p.consume("]"):
+			return nil
+		case p.consume("%"):
+			if err := p.paramRef(); err != nil {
+				return err
+			}
+		case p.consume("<!--"):
+			if !p.skipPast("-->") {
+				return p.errorf("expected '-->' to end comment")
+			}
+		case p.consume("<?"):
+			if !p.skipPast("?>") {
+				return p.errorf("expected '?>' to end processing instruction")
+			}
+		case p.consume("<!ELEMENT"):
+			if err := p.elementDecl(); err != nil {
+				return err
+			}
+		case p.consume("<!ATTLIST"):
+			if err := p.attlistDecl(); err != nil {
+				return err
+			}
+		case p.consume("<!ENTITY"):
+			if err := p.entityDecl(); err != nil {
+				return err
+			}
+		case p.consume("<!NOTATION"):
+			if err := p.notationDecl(); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("unexpected %q in internal subset", p.rest(10))
+		}
+	}
+}
+
+// paramRef expands a parameter entity reference like `%name;` between declarations, after the
+// '%' has been consumed.
+func (p *dtdParser) paramRef() error {
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if !p.consume(";") {
+		return p.errorf("expected ';' after %%%s", name)
+	}
+	e := p.dtd.params[name]
+	if e == nil {
+		return p.errorf("undeclared parameter entity %%%s;", name)
+	}
+	if e.External() {
+		// External DTDs are never fetched.
+		return nil
+	}
+	if len(p.expanding) >= maxDTDDepth {
+		return p.errorf("parameter entities nested too deep at %%%s;", name)
+	}
+	for _, n := range p.expanding {
+		if n == name {
+			return p.errorf("recursive parameter entity %%%s;", name)
+		}
+	}
+	sub := &dtdParser{data: []byte(e.Value), dtd: p.dtd, expanding: append(p.expanding, name)}
+	return sub.subset(false)
+}
+
+// elementDecl parses `name contentspec>` after `<!ELEMENT`.
+func (p *dtdParser) elementDecl() error {
+	if !p.space() {
+		return p.errorf("expected space after <!ELEMENT")
+	}
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if !p.space() {
+		return p.errorf("expected space after <!ELEMENT %s", name)
+	}
+	decl := &ElementDecl{Name: name}
+	switch {
+	case p.consume("EMPTY"):
+		decl.Kind = ContentEmpty
+	case p.consume("ANY"):
+		decl.Kind = ContentAny
+	case p.peek('('):
+		save := p.pos
+		p.pos++
+		p.space()
+		if p.consume("#PCDATA") {
+			decl.Kind = ContentMixed
+			if decl.Mixed, err = p.mixed(); err != nil {
+				return err
+			}
+			break
+		}
+		p.pos = save
+		decl.Kind = ContentChildren
+		if decl.Model, err = p.particle(); err != nil {
+			return err
+		}
+		if decl.Model.Name != "" {
+			return p.errorf("content model of <!ELEMENT %s> must be a group", name)
+		}
+	default:
+		return p.errorf("expected content of <!ELEMENT %s>", name)
+	}
+	p.space()
+	if !p.consume(">") {
+		return p.errorf("expected '>' to end <!ELEMENT %s>", name)
+	}
+	p.dtd.Elements = append(p.dtd.Elements, decl)
+	if p.dtd.elements[name] == nil {
+		p.dtd.elements[name] = decl
+	}
+	return nil
+}
+
+// mixed parses the rest of mixed content like `|a|b)*` after `(#PCDATA`.
+func (p *dtdParser) mixed() ([]string, error) {
+	var names []string
+	for {
+		p.space()
+		if p.consume(")") {
+			if len(names) > 0 && !p.consume("*") {
+				return nil, p.errorf("expected ')*' to end mixed content")
+			}
+			// (#PCDATA)* is allowed as well.
+			p.consume("*")
+			return names, nil
+		}
+		if !p.consume("|") {
+			return nil, p.errorf("expected '|' or ')' in mixed content")
+		}
+		p.space()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+}
+
+// particle parses an element name or a group like `(a,b)` with their optional occurrence suffix.
+func (p *dtdParser) particle() (*ContentParticle, error) {
+	cp := &ContentParticle{}
+	if p.consume("(") {
+		var sep byte
+		for {
+			p.space()
+			child, err := p.particle()
+			if err != nil {
+				return nil, err
+			}
+			cp.Children = append(cp.Children, child)
+			p.space()
+			if p.consume(")") {
+				break
+			}
+			if p.pos == len(p.data) || (p.data[p.pos] != '|' && p.data[p.pos] != ',') {
+				return nil, p.errorf("expected '|', ',' or ')' in content model")
+			}
+			if sep != 0 && p.data[p.pos] != sep {
+				return nil, p.errorf("can't mix '|' and ',' in a content model group")
+			}
+			sep = p.data[p.pos]
+			p.pos++
+		}
+		cp.Choice = sep == '|'
+	} else {
+		var err error
+		if cp.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.pos < len(p.data) {
+		switch c := p.data[p.pos]; c {
+		case '?', '*', '+':
+			cp.Occurs = rune(c)
+			p.pos++
+		}
+	}
+	return cp, nil
+}
+
+// attlistDecl parses `element (name type default)*>` after `<!ATTLIST`.
+func (p *dtdParser) attlistDecl() error {
+	if !p.space() {
+		return p.errorf("expected space after <!ATTLIST")
+	}
+	element, err := p.name()
+	if err != nil {
+		return err
+	}
+	decl := &AttlistDecl{Element: element}
+	for {
+		hasSpace := p.space()
+		if p.consume(">") {
+			break
+		}
+		if !hasSpace {
+			return p.errorf("expected space in <!ATTLIST %s>", element)
+		}
+		def, err := p.attDef()
+		if err != nil {
+			return fmt.Errorf("%w in <!ATTLIST %s>", err, element)
+		}
+		decl.Attrs = append(decl.Attrs, def)
+	}
+	p.dtd.Attlists = append(p.dtd.Attlists, decl)
+	for _, def := range decl.Attrs {
+		if !hasAttDef(p.dtd.attrs[element], def.Name) {
+			p.dtd.attrs[element] = append(p.dtd.attrs[element], def)
+		}
+	}
+	return nil
+}
+
+func hasAttDef(defs []*AttDef, name string) bool {
+	for _, def := range defs {
+		if def.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// attDefTypes are the attribute types besides enumerations.
+var attDefTypes = []string{"CDATA", "IDREFS", "IDREF", "ID", "ENTITY", "ENTITIES", "NMTOKENS", "NMTOKEN", "NOTATION"}
+
+// attDef parses an attribute definition like `name CDATA #IMPLIED`.
+func (p *dtdParser) attDef() (*AttDef, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	def := &AttDef{Name: name}
+	if !p.space() {
+		return nil, p.errorf("expected space after attribute %s", name)
+	}
+	for _, typ := range attDefTypes {
+		if p.consume(typ) {
+			def.Type = typ
+			break
+		}
+	}
+	if def.Type == "NOTATION" && !p.space() {
+		return nil, p.errorf("expected space after NOTATION")
+	}
+	if def.Type == "" || def.Type == "NOTATION" {
+		if def.Type == "" {
+			def.Type = "ENUMERATION"
+		}
+		if def.Enum, err = p.enumeration(def.Type == "ENUMERATION"); err != nil {
+			return nil, err
+		}
+	}
+	if !p.space() {
+		return nil, p.errorf("expected space after the type of attribute %s", name)
+	}
+	switch {
+	case p.consume("#REQUIRED"):
+		def.Default = "#REQUIRED"
+	case p.consume("#IMPLIED"):
+		def.Default = "#IMPLIED"
+	default:
+		if p.consume("#FIXED") {
+			def.Default = "#FIXED"
+			if !p.space() {
+				return nil, p.errorf("expected space after #FIXED")
+			}
+		}
+		if def.Value, err = p.quoted(); err != nil {
+			return nil, err
+		}
+	}
+	return def, nil
+}
+
+// enumeration parses a list of names like `(a|b|c)`, nmtokens also allows names starting with
+// any name character like `(1|2)`.
+func (p *dtdParser) enumeration(nmtokens bool) ([]string, error) {
+	if !p.consume("(") {
+		return nil, p.errorf("expected attribute type")
+	}
+	var values []string
+	for {
+		p.space()
+		var value string
+		var err error
+		if nmtokens {
+			value, err = p.nmtoken()
+		} else {
+			value, err = p.name()
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.space()
+		if p.consume(")") {
+			return values, nil
+		}
+		if !p.consume("|") {
+			return nil, p.errorf("expected '|' or ')' in enumeration")
+		}
+	}
+}
+
+// entityDecl parses `[%] name (value | externalID [NDATA name])>` after `<!ENTITY`.
+func (p *dtdParser) entityDecl() error {
+	if !p.space() {
+		return p.errorf("expected space after <!ENTITY")
+	}
+	decl := &EntityDecl{}
+	if p.consume("%") {
+		decl.Parameter = true
+		if !p.space() {
+			return p.errorf("expected space after <!ENTITY %%")
+		}
+	}
+	var err error
+	if decl.Name, err = p.name(); err != nil {
+		return err
+	}
+	if !p.space() {
+		return p.errorf("expected space after <!ENTITY %s", decl.Name)
+	}
+	if p.peek('"') || p.peek('\'') {
+		if decl.Value, err = p.entityValue(); err != nil {
+			return err
+		}
+	} else {
+		if decl.PublicID, decl.SystemID, err = p.externalID(false); err != nil {
+			return err
+		}
+		if p.space() && p.consume("NDATA") {
+			if decl.Parameter {
+				return p.errorf("parameter entity %%%s can't have NDATA", decl.Name)
+			}
+			if !p.space() {
+				return p.errorf("expected space after NDATA")
+			}
+			if decl.NData, err = p.name(); err != nil {
+				return err
+			}
+		}
+	}
+	p.space()
+	if !p.consume(">") {
+		return p.errorf("expected '>' to end <!ENTITY %s>", decl.Name)
+	}
+	p.dtd.Entities = append(p.dtd.Entities, decl)
+	table := p.dtd.entities
+	if decl.Parameter {
+		table = p.dtd.params
+	}
+	if table[decl.Name] == nil {
+		table[decl.Name] = decl
+	}
+	return nil
+}
+
+// entityValue parses a quoted entity value, decoding character references.
+func (p *dtdParser) entityValue() (string, error) {
+	value, err := p.quoted()
+	if err != nil {
+		return "", err
+	}
+	if strings.IndexByte(value, '%') >= 0 {
+		return "", p.errorf("parameter entity references are not allowed in entity values of the internal subset")
+	}
+	if strings.Index(value, "&#") < 0 {
+		return value, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(value, "&#")
+		if i < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(value[i:], ';')
+		if end < 0 {
+			return "", fmt.Errorf("%w, unterminated character reference in entity value", InvalidDTD)
+		}
+		r, ok := parseCharRef([]byte(value[i+2:i+end]), false)
+		if !ok {
+			return "", fmt.Errorf("%w %s in entity value", InvalidEntity, value[i:i+end+1])
+		}
+		b.WriteString(value[:i])
+		b.WriteRune(r)
+		value = value[i+end+1:]
+	}
+}
+
+// notationDecl parses `name externalID>` after `<!NOTATION`.
+func (p *dtdParser) notationDecl() error {
+	if !p.space() {
+		return p.errorf("expected space after <!NOTATION")
+	}
+	decl := &NotationDecl{}
+	var err error
+	if decl.Name, err = p.name(); err != nil {
+		return err
+	}
+	if !p.space() {
+		return p.errorf("expected space after <!NOTATION %s", decl.Name)
+	}
+	if decl.PublicID, decl.SystemID, err = p.externalID(true); err != nil {
+		return err
+	}
+	p.space()
+	if !p.consume(">") {
+		return p.errorf("expected '>' to end <!NOTATION %s>", decl.Name)
+	}
+	p.dtd.Notations = append(p.dtd.Notations, decl)
+	return nil
+}
+
+// externalID parses `SYSTEM "uri"` or `PUBLIC "id" "uri"`, the uri is optional for notations.
+func (p *dtdParser) externalID(notation bool) (public, system string, err error) {
+	switch {
+	case p.consume("SYSTEM"):
+		if !p.space() {
+			return "", "", p.errorf("expected space after SYSTEM")
+		}
+		system, err = p.quoted()
+		return "", system, err
+	case p.consume("PUBLIC"):
+		if !p.space() {
+			return "", "", p.errorf("expected space after PUBLIC")
+		}
+		if public, err = p.quoted(); err != nil {
+			return "", "", err
+		}
+		save := p.pos
+		if p.space() && (p.peek('"') || p.peek('\'')) {
+			system, err = p.quoted()
+			return public, system, err
+		}
+		if !notation {
+			return "", "", p.errorf("expected system identifier after PUBLIC %q", public)
+		}
+		p.pos = save
+		return public, "", nil
+	}
+	return "", "", p.errorf("expected SYSTEM or PUBLIC")
+}
+
+// quoted parses a string within single or double quotes.
+func (p *dtdParser) quoted() (string, error) {
+	if !p.peek('"') && !p.peek('\'') {
+		return "", p.errorf("expected quoted string")
+	}
+	quote := p.data[p.pos]
+	end := bytes.IndexByte(p.data[p.pos+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated quoted string")
+	}
+	s := string(p.data[p.pos+1 : p.pos+1+end])
+	p.pos += end + 2
+	return s, nil
+}
+
+// name parses a name like `msg` or `xml:space`.
+func (p *dtdParser) name() (string, error) {
+	start := p.pos
+	for p.pos < len(p.data) {
+		r, size := utf8.DecodeRune(p.data[p.pos:])
+		if !(isNameChar(r) || r == ':') || p.pos == start && !(isNameStartChar(r) || r == ':') {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return "", p.errorf("expected name but got %q", p.rest(10))
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+// nmtoken parses a name token, which may start with any name character like `1a`.
+func (p *dtdParser) nmtoken() (string, error) {
+	start := p.pos
+	for p.pos < len(p.data) {
+		r, size := utf8.DecodeRune(p.data[p.pos:])
+		if !isNameChar(r) && r != ':' {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return "", p.errorf("expected name token but got %q", p.rest(10))
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+// space skips whitespace and reports whether there was any.
+func (p *dtdParser) space() bool {
+	start := p.pos
+	for p.pos < len(p.data) && isSpaceByte(p.data[p.pos]) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// consume skips s if the data continues with it.
+func (p *dtdParser) consume(s string) bool {
+	if !bytes.HasPrefix(p.data[p.pos:], []byte(s)) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+// skipPast skips the data up to and including end.
+func (p *dtdParser) skipPast(end string) bool {
+	i := bytes.Index(p.data[p.pos:], []byte(end))
+	if i < 0 {
+		return false
+	}
+	p.pos += i + len(end)
+	return true
+}
+
+func (p *dtdParser) peek(c byte) bool {
+	return p.pos < len(p.data) && p.data[p.pos] == c
+}
+
+// rest returns up to n bytes of the data left, for error messages.
+func (p *dtdParser) rest(n int) string {
+	rest := p.data[p.pos:]
+	if len(rest) > n {
+		rest = rest[:n]
+	}
+	return string(rest)
+}
+
+func (p *dtdParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w, "+format, append([]interface{}{InvalidDTD}, args...)...)
+}
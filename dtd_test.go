@@ -0,0 +1,324 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// xmbDTD is the DTD of XMB files, with a few extra declarations.
+const xmbDTD = `<!DOCTYPE messagebundle PUBLIC "-//Google//DTD XMB 1.0//EN" "messagebundle.dtd" [
+<!ELEMENT messagebundle (msg)*>
+<!ATTLIST messagebundle class CDATA #IMPLIED>
+<!-- Messages can't contain "]>" -->
+<!ELEMENT msg (#PCDATA|ph|source)*>
+<!ATTLIST msg id CDATA #IMPLIED
+              desc CDATA #IMPLIED
+              obsolete (obsolete) #IMPLIED>
+<!ATTLIST msg xml:space (default|preserve) "default" id CDATA #REQUIRED>
+<!ELEMENT source (#PCDATA)>
+<!ELEMENT ph (#PCDATA|ex)*>
+<!ATTLIST ph name CDATA #REQUIRED format NOTATION (printf) #FIXED 'printf'>
+<!ELEMENT ex (#PCDATA)>
+<!ELEMENT meta (name, (value | ref+)?, note*)>
+<!ELEMENT empty EMPTY>
+<!ENTITY % text "(#PCDATA)">
+<!ENTITY % note "<!ELEMENT note (#PCDATA)>">
+%note;
+<!ENTITY product "Go &#x2764;&#65039; &version;">
+<!ENTITY logo SYSTEM "logo.gif" NDATA gif>
+<!NOTATION gif PUBLIC "image/gif">
+<!NOTATION printf SYSTEM "printf">
+<?comment ignored ]> ?>
+]>`
+
+func TestDecodeDTD(t *testing.T) {
+	d := NewDecoder(strings.NewReader(xmbDTD + "\n<messagebundle/>"))
+	d.ReadDTD = true
+	if _, err := d.Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &DTD{
+		Name:     "messagebundle",
+		PublicID: "-//Google//DTD XMB 1.0//EN",
+		SystemID: "messagebundle.dtd",
+		Elements: []*ElementDecl{
+			{Name: "messagebundle", Kind: ContentChildren, Model: &ContentParticle{Children: []*ContentParticle{{Name: "msg"}}, Occurs: '*'}},
+			{Name: "msg", Kind: ContentMixed, Mixed: []string{"ph", "source"}},
+			{Name: "source", Kind: ContentMixed},
+			{Name: "ph", Kind: ContentMixed, Mixed: []string{"ex"}},
+			{Name: "ex", Kind: ContentMixed},
+			{Name: "meta", Kind: ContentChildren, Model: &ContentParticle{Children: []*ContentParticle{
+				{Name: "name"},
+				{Choice: true, Children: []*ContentParticle{{Name: "value"}, {Name: "ref", Occurs: '+'}}, Occurs: '?'},
+				{Name: "note", Occurs: '*'},
+			}}},
+			{Name: "empty", Kind: ContentEmpty},
+			{Name: "note", Kind: ContentMixed},
+		},
+		Attlists: []*AttlistDecl{
+			{Element: "messagebundle", Attrs: []*AttDef{{Name: "class", Type: "CDATA", Default: "#IMPLIED"}}},
+			{Element: "msg", Attrs: []*AttDef{
+				{Name: "id", Type: "CDATA", Default: "#IMPLIED"},
+				{Name: "desc", Type: "CDATA", Default: "#IMPLIED"},
+				{Name: "obsolete", Type: "ENUMERATION", Enum: []string{"obsolete"}, Default: "#IMPLIED"},
+			}},
+			{Element: "msg", Attrs: []*AttDef{
+				{Name: "xml:space", Type: "ENUMERATION", Enum: []string{"default", "preserve"}, Value: "default"},
+				{Name: "id", Type: "CDATA", Default: "#REQUIRED"},
+			}},
+			{Element: "ph", Attrs: []*AttDef{
+				{Name: "name", Type: "CDATA", Default: "#REQUIRED"},
+				{Name: "format", Type: "NOTATION", Enum: []string{"printf"}, Default: "#FIXED", Value: "printf"},
+			}},
+		},
+		Entities: []*EntityDecl{
+			{Name: "text", Parameter: true, Value: "(#PCDATA)"},
+			{Name: "note", Parameter: true, Value: "<!ELEMENT note (#PCDATA)>"},
+			{Name: "product", Value: "Go ❤️ &version;"},
+			{Name: "logo", SystemID: "logo.gif", NData: "gif"},
+		},
+		Notations: []*NotationDecl{
+			{Name: "gif", PublicID: "image/gif"},
+			{Name: "printf", SystemID: "printf"},
+		},
+	}
+	got := d.DTD()
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(DTD{})); diff != "" {
+		t.Error("DTD diff (-want +got)\n", diff)
+	}
+
+	if got := got.Element("meta").Model.String(); got != "(name,(value|ref+)?,note*)" {
+		t.Errorf("meta content model: %s", got)
+	}
+	var attrs []string
+	for _, def := range got.Attributes("msg") {
+		attrs = append(attrs, def.Name)
+	}
+	if diff := cmp.Diff([]string{"id", "desc", "obsolete", "xml:space"}, attrs); diff != "" {
+		t.Error("msg attributes diff (-want +got)\n", diff)
+	}
+	if e := got.Entity("product"); e == nil || e.External() {
+		t.Errorf("Entity(product): %+v", e)
+	}
+	if e := got.Entity("text"); e != nil {
+		t.Errorf("Entity(text) must not return parameter entities, got %+v", e)
+	}
+}
+
+func TestDecodeDTDDirective(t *testing.T) {
+	const input = `<!DOCTYPE a SYSTEM "a.dtd"><a/>`
+	d := NewDecoder(strings.NewReader(input))
+	d.ReadDTD = true
+	d.ReadDirective = true
+	var got []Token
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tok.Copy())
+	}
+	want := []Token{
+		&Directive{Data: []byte(`DOCTYPE a SYSTEM "a.dtd"`)},
+		&StartTag{Name: &Name{local: "a"}},
+		&CloseTag{&Name{local: "a"}},
+	}
+	opts := cmp.Options{
+		cmp.AllowUnexported(Name{}),
+		cmp.Transformer("byteToString", func(in []byte) string { return string(in) }),
+	}
+	if diff := cmp.Diff(want, got, opts); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+	if dtd := d.DTD(); dtd == nil || dtd.Name != "a" || dtd.SystemID != "a.dtd" {
+		t.Errorf("DTD(): %+v", dtd)
+	}
+}
+
+func TestDecodeDTDErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  string
+	}{
+		{"not doctype", `<!DOC a>`, "expected 'DOCTYPE '"},
+		{"missing name", `<!DOCTYPE >`, "expected name"},
+		{"bad external id", `<!DOCTYPE a FOO "b">`, "expected SYSTEM or PUBLIC"},
+		{"missing system id", `<!DOCTYPE a PUBLIC "b">`, `expected system identifier after PUBLIC "b"`},
+		{"unterminated subset", `<!DOCTYPE a [<!ELEMENT a ANY>`, "unexpected EOF, expected '>' for DOCTYPE"},
+		{"unknown declaration", `<!DOCTYPE a [<!FOO a>]>`, `unexpected "<!FOO a>]>" in internal subset`},
+		{"bad content", `<!DOCTYPE a [<!ELEMENT a FOO>]>`, "expected content of <!ELEMENT a>"},
+		{"mixed separators", `<!DOCTYPE a [<!ELEMENT a (b,c|d)>]>`, "can't mix '|' and ',' in a content model group"},
+		{"mixed without star", `<!DOCTYPE a [<!ELEMENT a (#PCDATA|b)>]>`, "expected ')*' to end mixed content"},
+		{"bad attribute type", `<!DOCTYPE a [<!ATTLIST a b FOO #IMPLIED>]>`, "expected attribute type in <!ATTLIST a>"},
+		{"bad attribute default", `<!DOCTYPE a [<!ATTLIST a b CDATA #FOO>]>`, "expected quoted string in <!ATTLIST a>"},
+		{"undeclared parameter", `<!DOCTYPE a [%foo;]>`, "undeclared parameter entity %foo;"},
+		{"recursive parameter", `<!DOCTYPE a [<!ENTITY % a "%a;"> %a;]>`, "parameter entity references are not allowed"},
+		{"bad char ref", `<!DOCTYPE a [<!ENTITY a "&#0;">]>`, "invalid entity &#0; in entity value"},
+		{"twice", `<!DOCTYPE a><!DOCTYPE a>`, "DOCTYPE must appear once before the root element"},
+		{"after root", `<a><!DOCTYPE a></a>`, "DOCTYPE must appear once before the root element"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.ReadDTD = true
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDTDEntities(t *testing.T) {
+	const input = `<!DOCTYPE msg [
+<!ENTITY version "1.14">
+<!ENTITY product "Go &version; &#x2764;">
+<!ENTITY amp2 "&#38;amp;">
+<!ATTLIST msg lang CDATA "en &version;"
+              format (text|html) #FIXED "text"
+              id CDATA #IMPLIED
+              desc CDATA #REQUIRED>
+<!ATTLIST ph name CDATA "x">
+]>
+<msg desc="&product;" lang="es">&product; &amp2;<ph/><ph name="y"/></msg>`
+	d := NewDecoder(strings.NewReader(input))
+	d.ReadDTD = true
+	var got []Token
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := tok.(*Directive); ok {
+			continue
+		}
+		got = append(got, tok.Copy())
+	}
+	msg, ph := &Name{local: "msg"}, &Name{local: "ph"}
+	want := []Token{
+		&CharData{Data: []byte(" ")},
+		&StartTag{Name: msg, Attr: []*Attr{
+			{Name: &Name{local: "desc"}, Value: "Go 1.14 ❤"},
+			{Name: &Name{local: "lang"}, Value: "es"},
+			{Name: &Name{local: "format"}, Value: "text"},
+		}},
+		&CharData{Data: []byte("Go 1.14 ❤ &")},
+		&StartTag{Name: ph, Attr: []*Attr{{Name: &Name{local: "name"}, Value: "x"}}},
+		&CloseTag{Name: ph},
+		&StartTag{Name: ph, Attr: []*Attr{{Name: &Name{local: "name"}, Value: "y"}}},
+		&CloseTag{Name: ph},
+		&CloseTag{Name: msg},
+	}
+	opts := cmp.Options{
+		cmp.AllowUnexported(Name{}),
+		cmp.Transformer("byteToString", func(in []byte) string { return string(in) }),
+	}
+	if diff := cmp.Diff(want, got, opts); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+}
+
+func TestDecodeDTDDefaultNamespace(t *testing.T) {
+	const input = `<!DOCTYPE feed [<!ATTLIST feed xmlns CDATA #FIXED "http://www.w3.org/2005/Atom">]><feed/>`
+	d := NewDecoder(strings.NewReader(input))
+	d.ReadDTD = true
+	d.Namespaces = true
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start, ok := tok.(*StartTag); ok {
+			if got := start.Name.URI(); got != "http://www.w3.org/2005/Atom" {
+				t.Errorf("URI(): %q", got)
+			}
+			break
+		}
+	}
+}
+
+func TestDecodeDTDEntityErrors(t *testing.T) {
+	const laughs = `<!DOCTYPE lolz [
+<!ENTITY lol "lol">
+<!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+<!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+<!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">
+<!ENTITY lol4 "&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;">
+<!ENTITY lol5 "&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;">
+<!ENTITY lol6 "&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;">
+<!ENTITY lol7 "&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;">
+<!ENTITY lol8 "&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;">
+<!ENTITY lol9 "&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;">
+]>`
+	var deep strings.Builder
+	deep.WriteString(`<!DOCTYPE a [<!ENTITY e0 "x">`)
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&deep, `<!ENTITY e%d "&e%d;">`, i, i-1)
+	}
+	deep.WriteString(`]>`)
+
+	testCases := []struct {
+		desc    string
+		input   string
+		wantErr error
+		want    string
+	}{
+		{"billion laughs", laughs + `<lolz>&lol9;</lolz>`, EntityLimit, "entity expanded into more than 1048576 bytes"},
+		{"billion laughs attribute", laughs + `<lolz a="&lol9;"/>`, EntityLimit, "entity expanded into more than 1048576 bytes"},
+		{"too many expansions", laughs + `<lolz>` + strings.Repeat("&lol5;", 100) + `</lolz>`, EntityLimit, "entities expanded into more than 16777216 bytes"},
+		{"too deep", deep.String() + `<a>&e20;</a>`, EntityLimit, "entities nested more than 16 levels deep"},
+		{"recursive", `<!DOCTYPE a [<!ENTITY a "x&b;"><!ENTITY b "&a;">]><a>&a;</a>`, InvalidEntity, "&a;, recursive entity"},
+		{"unknown", `<!DOCTYPE a [<!ENTITY a "&b;">]><a>&a;</a>`, InvalidEntity, "&b;, unknown entity"},
+		{"unterminated", `<!DOCTYPE a [<!ENTITY a "x &b">]><a>&a;</a>`, InvalidEntity, `"&b", expected ';'`},
+		{"external", `<!DOCTYPE a [<!ENTITY a SYSTEM "a.xml">]><a>&a;</a>`, InvalidEntity, "external entities are not supported"},
+		{"unparsed", `<!DOCTYPE a [<!ENTITY a SYSTEM "a.gif" NDATA gif>]><a>&a;</a>`, InvalidEntity, "can't reference an unparsed entity"},
+		{"bad default", `<!DOCTYPE a [<!ATTLIST a b CDATA "&c;">]><a/>`, InvalidEntity, "&c;, unknown entity in the default value of attribute b on tag <a>"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.ReadDTD = true
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.wantErr)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
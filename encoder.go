@@ -0,0 +1,382 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Encoder writes XML into an output stream.
+type Encoder struct {
+	w *bufio.Writer
+
+	// Indentation settings, see Encoder.Indent
+	prefix     string
+	indent     string
+	depth      int
+	indentedIn bool
+	putNewline bool
+
+	// buf is a scratch buffer used to format values before escaping them into the output. This
+	// buffer is reused for every value to save on allocations.
+	buf []byte
+
+	// tags is the stack of open StartTag names written with EncodeToken, used to verify that every
+	// CloseTag matches.
+	tags []*Name
+}
+
+// NewEncoder instantiates an Encoder that writes to w.
+//
+// The output is buffered, Encode flushes it after every value.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:   bufio.NewWriterSize(w, 4096),
+		buf: make([]byte, 0, 64),
+	}
+}
+
+// Indent sets the encoder to generate XML in which each element begins on a new indented line that
+// starts with prefix and is followed by one or more copies of indent according to the nesting
+// depth.
+func (e *Encoder) Indent(prefix, indent string) {
+	e.prefix = prefix
+	e.indent = indent
+}
+
+// Flush writes any buffered XML to the underlying writer.
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+// EncodeToken writes the given XML token to the stream. It returns an error if StartTag and
+// CloseTag tokens are not properly matched.
+//
+// EncodeToken does not flush the output, call Flush or Close once done. Tokens returned by a
+// Decoder can be passed directly, their contents are written before EncodeToken returns.
+func (e *Encoder) EncodeToken(t Token) error {
+	switch t := t.(type) {
+	case *StartTag:
+		if t.Name == nil || t.Name.local == "" {
+			return errors.New("start tag with missing name")
+		}
+		e.openStartTag(t)
+		e.w.WriteByte('>')
+		e.tags = append(e.tags, t.Name)
+	case *CloseTag:
+		if t.Name == nil || t.Name.local == "" {
+			return errors.New("end tag with missing name")
+		}
+		if len(e.tags) == 0 {
+			return fmt.Errorf("end tag </%s> without start tag", t.Name)
+		}
+		if top := e.tags[len(e.tags)-1]; !sameName(top, t.Name) {
+			return fmt.Errorf("end tag </%s> does not match start tag <%s>", t.Name, top)
+		}
+		e.tags = e.tags[:len(e.tags)-1]
+		e.writeEnd(t.Name)
+	case *CharData:
+		if t.CDATA {
+			e.writeCDATA(t.Data)
+			return nil
+		}
+		return escapeText(e.w, t.Data, false)
+	case *Comment:
+		if bytes.Contains(t.Data, commentEnd) {
+			return errors.New(`comments must not contain "--"`)
+		}
+		e.w.WriteString("<!--")
+		e.w.Write(t.Data)
+		if len(t.Data) > 0 && t.Data[len(t.Data)-1] == '-' {
+			// "--->" is invalid grammar. Make it "- -->"
+			e.w.WriteByte(' ')
+		}
+		e.w.WriteString("-->")
+	case *ProcInst:
+		if t.Target == "" {
+			return errors.New("proc inst with missing target")
+		}
+		if bytes.Contains(t.Inst, procInstEnd) {
+			return errors.New(`proc inst must not contain "?>"`)
+		}
+		e.w.WriteString("<?")
+		e.w.WriteString(t.Target)
+		if len(t.Inst) > 0 {
+			e.w.WriteByte(' ')
+			e.w.Write(t.Inst)
+		} else if t.Decl != nil {
+			e.writeDeclaration(t.Decl)
+		}
+		e.w.WriteString("?>")
+	case *Directive:
+		if !isValidDirective(t.Data) {
+			return errors.New("invalid directive, unbalanced brackets or '>' outside of them")
+		}
+		e.w.WriteString("<!")
+		e.w.Write(t.Data)
+		e.w.WriteByte('>')
+	default:
+		return fmt.Errorf("invalid token type %T", t)
+	}
+	return nil
+}
+
+// EncodeTextElement writes a whole element with the escaped text as its only content, like
+// `<name>text</name>`.
+func (e *Encoder) EncodeTextElement(name *Name, text []byte) error {
+	if name == nil || name.local == "" {
+		return errors.New("start tag with missing name")
+	}
+	e.writeIndent(1)
+	e.w.WriteByte('<')
+	e.writeName(name)
+	e.w.WriteByte('>')
+	if err := escapeText(e.w, text, false); err != nil {
+		return err
+	}
+	e.writeEnd(name)
+	return nil
+}
+
+// Close flushes the output and reports an error if any StartTag written with EncodeToken was left
+// without its CloseTag.
+func (e *Encoder) Close() error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if len(e.tags) > 0 {
+		return fmt.Errorf("unclosed tag <%s>", e.tags[len(e.tags)-1])
+	}
+	return nil
+}
+
+// sameName reports whether two names have the same namespace and local name, names from different
+// Decoder instances, or created with NewName, don't share the same pointer.
+func sameName(a, b *Name) bool {
+	return a == b || (a.local == b.local && a.space == b.space)
+}
+
+// isValidDirective reports whether the Decoder can read back the directive contents, which end at
+// the first '>' outside of [] or {} brackets.
+func isValidDirective(dir []byte) bool {
+	var target byte
+	for _, c := range dir {
+		switch {
+		case target != 0:
+			if c == target {
+				target = 0
+			}
+		case c == '[':
+			target = ']'
+		case c == '{':
+			target = '}'
+		case c == '>':
+			return false
+		}
+	}
+	return target == 0
+}
+
+// writeIndent writes the newline and indentation for the next element, depthDelta is the change of
+// depth caused by the element.
+func (e *Encoder) writeIndent(depthDelta int) {
+	if len(e.prefix) == 0 && len(e.indent) == 0 {
+		return
+	}
+	if depthDelta < 0 {
+		e.depth--
+		if e.indentedIn {
+			// The element had no child elements, close it on the same line.
+			e.indentedIn = false
+			return
+		}
+	}
+	e.indentedIn = false
+	if e.putNewline {
+		e.w.WriteByte('\n')
+	} else {
+		e.putNewline = true
+	}
+	e.w.WriteString(e.prefix)
+	for i := 0; i < e.depth; i++ {
+		e.w.WriteString(e.indent)
+	}
+	if depthDelta > 0 {
+		e.depth++
+		e.indentedIn = true
+	}
+}
+
+// writeName writes the identifier including its namespace like "a:b".
+func (e *Encoder) writeName(name *Name) {
+	if name.space != "" {
+		e.w.WriteString(name.space)
+		e.w.WriteByte(':')
+	}
+	e.w.WriteString(name.local)
+}
+
+// openStartTag writes the beginning of a tag like `<foo a="b"`, additional attributes may be written
+// with writeAttr before closing it with `>`.
+func (e *Encoder) openStartTag(start *StartTag) {
+	e.writeIndent(1)
+	e.w.WriteByte('<')
+	e.writeName(start.Name)
+	for _, attr := range start.Attr {
+		if attr == nil || attr.Name == nil || attr.Name.local == "" {
+			continue
+		}
+		e.buf = append(e.buf[:0], attr.Value...)
+		e.writeAttr(attr.Name, e.buf)
+	}
+}
+
+// writeAttr writes an attribute like ` name="value"`, escaping the value.
+func (e *Encoder) writeAttr(name *Name, value []byte) {
+	e.w.WriteByte(' ')
+	e.writeName(name)
+	e.w.WriteString(`="`)
+	escapeText(e.w, value, true)
+	e.w.WriteByte('"')
+}
+
+var procInstEnd = []byte("?>")
+
+// writeDeclaration writes the fields of an XML declaration, for ProcInst tokens without contents.
+func (e *Encoder) writeDeclaration(decl *Declaration) {
+	e.w.WriteString(` version="`)
+	e.w.WriteString(decl.Version)
+	e.w.WriteByte('"')
+	if decl.Encoding != "" {
+		e.w.WriteString(` encoding="`)
+		e.w.WriteString(decl.Encoding)
+		e.w.WriteByte('"')
+	}
+	if decl.Standalone {
+		e.w.WriteString(` standalone="yes"`)
+	}
+}
+
+// writeCDATA writes the text in a section like <![CDATA[ foo ]]>, splitting it wherever the text
+// contains the `]]>` terminator.
+func (e *Encoder) writeCDATA(s []byte) {
+	e.w.WriteString("<![CDATA[")
+	for {
+		i := bytes.Index(s, cdataTerminator)
+		if i < 0 {
+			break
+		}
+		// Close the section between "]]" and ">", and open a new one.
+		e.w.Write(s[:i+len(cdataEnd)])
+		e.w.WriteString("]]><![CDATA[")
+		s = s[i+len(cdataEnd):]
+	}
+	e.w.Write(s)
+	e.w.WriteString("]]>")
+}
+
+var cdataTerminator = []byte("]]>")
+
+// writeEnd writes a closing tag like </foo>.
+func (e *Encoder) writeEnd(name *Name) {
+	e.writeIndent(-1)
+	e.w.WriteString("</")
+	e.writeName(name)
+	e.w.WriteByte('>')
+}
+
+var (
+	escQuot = []byte("&#34;") // shorter than "&quot;"
+	escApos = []byte("&#39;") // shorter than "&apos;"
+	escAmp  = []byte("&amp;")
+	escLT   = []byte("&lt;")
+	escGT   = []byte("&gt;")
+	escTab  = []byte("&#x9;")
+	escNL   = []byte("&#xA;")
+	escCR   = []byte("&#xD;")
+	escFFFD = []byte("\uFFFD") // Unicode replacement character
+)
+
+// EscapeText writes to w the properly escaped XML equivalent of the plain text data s.
+func EscapeText(w io.Writer, s []byte) error {
+	return escapeText(w, s, true)
+}
+
+// escapeText writes to w the properly escaped XML equivalent of the plain text data s. Tabs and
+// newlines are only escaped when escapeSpace is set, attribute values need it because parsers
+// normalize them into spaces.
+func escapeText(w io.Writer, s []byte, escapeSpace bool) error {
+	var esc []byte
+	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		i += width
+		switch r {
+		case '"':
+			esc = escQuot
+		case '\'':
+			esc = escApos
+		case '&':
+			esc = escAmp
+		case '<':
+			esc = escLT
+		case '>':
+			esc = escGT
+		case '\t':
+			if !escapeSpace {
+				continue
+			}
+			esc = escTab
+		case '\n':
+			if !escapeSpace {
+				continue
+			}
+			esc = escNL
+		case '\r':
+			esc = escCR
+		default:
+			if !isInCharacterRange(r) || (r == utf8.RuneError && width == 1) {
+				esc = escFFFD
+				break
+			}
+			continue
+		}
+		if _, err := w.Write(s[last : i-width]); err != nil {
+			return err
+		}
+		if _, err := w.Write(esc); err != nil {
+			return err
+		}
+		last = i
+	}
+	_, err := w.Write(s[last:])
+	return err
+}
+
+// isInCharacterRange reports whether r is in the XML Character Range as defined by the spec:
+// https://www.w3.org/TR/xml/#charsets
+func isInCharacterRange(r rune) bool {
+	return r == 0x09 ||
+		r == 0x0A ||
+		r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEncodeToken(t *testing.T) {
+	tokens := []Token{
+		&ProcInst{Target: "xml", Decl: &Declaration{Version: "1.0", Encoding: "UTF-8"}},
+		&ProcInst{Target: "xml-stylesheet", Inst: []byte(`href="a.xsl"`)},
+		&StartTag{Name: NewName("ns", "root"), Attr: []*Attr{{Name: NewName("", "a"), Value: `"<&>"`}}},
+		&CharData{Data: []byte("1 < 2 & 3")},
+		&CharData{Data: []byte("1 < 2 ]]> 3"), CDATA: true},
+		&Comment{Data: []byte(" note ")},
+		&Directive{Data: []byte("DOCTYPE root [<!ENTITY x 'y'>]")},
+		&StartTag{Name: NewName("", "empty")},
+		&CloseTag{Name: NewName("", "empty")},
+		&CloseTag{Name: NewName("ns", "root")},
+	}
+
+	const want = `<?xml version="1.0" encoding="UTF-8"?><?xml-stylesheet href="a.xsl"?>` +
+		`<ns:root a="&#34;&lt;&amp;&gt;&#34;">1 &lt; 2 &amp; 3` +
+		`<![CDATA[1 < 2 ]]]]><![CDATA[> 3]]><!-- note -->` +
+		`<!DOCTYPE root [<!ENTITY x 'y'>]><empty></empty></ns:root>`
+
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	for _, tok := range tokens {
+		if err := e.EncodeToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("EncodeToken:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncodeTokenPipeline(t *testing.T) {
+	const input = `<msg id="1"><source>a.go</source>Bat<ph name="x"/></msg>`
+	const want = `<msg id="1"><source>a.go</source>Bat<ph name="x"></ph></msg>`
+
+	d := NewDecoder(strings.NewReader(input))
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.EncodeToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("EncodeToken:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncodeTokenErrors(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		tokens []Token
+		want   string
+	}{
+		{
+			desc:   "missing start name",
+			tokens: []Token{&StartTag{}},
+			want:   "start tag with missing name",
+		},
+		{
+			desc:   "missing end name",
+			tokens: []Token{&CloseTag{}},
+			want:   "end tag with missing name",
+		},
+		{
+			desc:   "end without start",
+			tokens: []Token{&CloseTag{Name: NewName("", "a")}},
+			want:   "end tag </a> without start tag",
+		},
+		{
+			desc:   "mismatch",
+			tokens: []Token{&StartTag{Name: NewName("", "a")}, &CloseTag{Name: NewName("", "b")}},
+			want:   "end tag </b> does not match start tag <a>",
+		},
+		{
+			desc:   "namespace mismatch",
+			tokens: []Token{&StartTag{Name: NewName("x", "a")}, &CloseTag{Name: NewName("y", "a")}},
+			want:   "end tag </y:a> does not match start tag <x:a>",
+		},
+		{
+			desc:   "bad comment",
+			tokens: []Token{&Comment{Data: []byte("a--b")}},
+			want:   `comments must not contain "--"`,
+		},
+		{
+			desc:   "bad directive",
+			tokens: []Token{&Directive{Data: []byte("a>b")}},
+			want:   "invalid directive, unbalanced brackets or '>' outside of them",
+		},
+		{
+			desc:   "unclosed directive bracket",
+			tokens: []Token{&Directive{Data: []byte("a[b")}},
+			want:   "invalid directive, unbalanced brackets or '>' outside of them",
+		},
+		{
+			desc:   "proc inst missing target",
+			tokens: []Token{&ProcInst{}},
+			want:   "proc inst with missing target",
+		},
+		{
+			desc:   "bad proc inst",
+			tokens: []Token{&ProcInst{Target: "a", Inst: []byte("b?>c")}},
+			want:   `proc inst must not contain "?>"`,
+		},
+		{
+			desc:   "unclosed",
+			tokens: []Token{&StartTag{Name: NewName("", "a")}, &StartTag{Name: NewName("", "b")}, &CloseTag{Name: NewName("", "b")}},
+			want:   "unclosed tag <a>",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			e := NewEncoder(ioutil.Discard)
+			var err error
+			for _, tok := range tc.tokens {
+				if err = e.EncodeToken(tok); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = e.Close()
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncodeTextElement(t *testing.T) {
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	e.Indent("", " ")
+	root := NewName("", "root")
+	if err := e.EncodeToken(&StartTag{Name: root}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.EncodeTextElement(NewName("", "a"), []byte("1 < 2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.EncodeToken(&CloseTag{Name: root}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "<root>\n <a>1 &lt; 2</a>\n</root>"
+	if got := b.String(); got != want {
+		t.Errorf("EncodeTextElement:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEntityLen bounds the length of an entity name, so a stray `&` doesn't consume the rest of the
+// input looking for its `;`.
+const maxEntityLen = 32
+
+// readEntity reads an entity like `&amp;` or a character reference like `&#38;` or `&#x26;` after
+// the `&` was consumed, and writes the decoded text into d.buf.
+func (d *Decoder) readEntity() error {
+	d.entityBuf = d.entityBuf[:0]
+	for {
+		r, err := d.next()
+		if err != nil {
+			return fmt.Errorf("%w, unterminated entity &%s", checkUnexpectedEOF(err), d.entityBuf)
+		}
+		if r == ';' {
+			break
+		}
+		if unicode.IsSpace(r) || r == '<' || r == '&' || r == '"' || r == '\'' || len(d.entityBuf) >= maxEntityLen {
+			return fmt.Errorf("%w &%s, expected ';' but got %q", InvalidEntity, d.entityBuf, r)
+		}
+		d.entityBuf = append(d.entityBuf, string(r)...)
+	}
+
+	name := d.entityBuf
+	if len(name) > 0 && name[0] == '#' {
+		r, ok := parseCharRef(name[1:], d.isXML11())
+		if !ok {
+			return fmt.Errorf("%w &%s;", InvalidEntity, name)
+		}
+		d.buf.WriteRune(r)
+		return nil
+	}
+
+	switch string(name) {
+	case "amp":
+		d.buf.WriteByte('&')
+	case "lt":
+		d.buf.WriteByte('<')
+	case "gt":
+		d.buf.WriteByte('>')
+	case "quot":
+		d.buf.WriteByte('"')
+	case "apos":
+		d.buf.WriteByte('\'')
+	default:
+		if text, ok := d.Entity[string(name)]; ok {
+			d.buf.WriteString(text)
+			return nil
+		}
+		if d.dtd != nil {
+			if e := d.dtd.entities[string(name)]; e != nil {
+				return d.writeDTDEntity(e)
+			}
+		}
+		if !d.KeepUnknownEntities {
+			return fmt.Errorf("%w &%s;, unknown entity", InvalidEntity, name)
+		}
+		d.buf.WriteByte('&')
+		d.buf.Write(name)
+		d.buf.WriteByte(';')
+	}
+	return nil
+}
+
+// parseCharRef parses the number of a character reference like `38` or `x26`, and reports whether
+// it's a valid XML character. XML 1.1 also allows the control characters #x1-#x1F.
+func parseCharRef(ref []byte, xml11 bool) (rune, bool) {
+	base := 10
+	if len(ref) > 0 && ref[0] == 'x' {
+		base = 16
+		ref = ref[1:]
+	}
+	if len(ref) == 0 || ref[0] == '+' || ref[0] == '-' {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(ref), base, 32)
+	if err != nil {
+		return 0, false
+	}
+	r := rune(n)
+	if xml11 && r >= 0x01 && r <= 0x1F {
+		// XML 1.1 allows references to the restricted control characters.
+		return r, true
+	}
+	return r, utf8.ValidRune(r) && isInCharacterRange(r)
+}
+
+// Limits on expanding the entities declared by the DTD, so documents like the "billion laughs"
+// attack, where every entity references the previous one ten times, fail fast instead of exhausting
+// memory.
+const (
+	// maxEntityDepth bounds the nesting of entities referenced within entity values.
+	maxEntityDepth = 16
+
+	// maxEntitySize bounds the replacement text of a single entity, including the entities it
+	// references.
+	maxEntitySize = 1 << 20
+
+	// maxEntityTotal bounds the text written by every entity expanded within a document.
+	maxEntityTotal = 16 << 20
+)
+
+// writeDTDEntity writes the replacement text of the general entity e declared by the DTD into d.buf.
+func (d *Decoder) writeDTDEntity(e *EntityDecl) error {
+	text, err := d.dtd.expand(e, d.isXML11(), nil)
+	if err != nil {
+		return err
+	}
+	d.expanded += len(text)
+	if d.expanded > maxEntityTotal {
+		return fmt.Errorf("%w, entities expanded into more than %d bytes", EntityLimit, maxEntityTotal)
+	}
+	d.buf.WriteString(text)
+	return nil
+}
+
+// expand returns the replacement text of the general entity e with the references within its value
+// expanded, expanding holds the entities being expanded to catch recursive references. The result
+// is cached so every entity is expanded only once.
+func (dtd *DTD) expand(e *EntityDecl, xml11 bool, expanding []*EntityDecl) (string, error) {
+	if text, ok := dtd.expanded[e]; ok {
+		return text, nil
+	}
+	switch {
+	case e.NData != "":
+		return "", fmt.Errorf("%w &%s;, can't reference an unparsed entity", InvalidEntity, e.Name)
+	case e.External():
+		// External DTDs are never fetched.
+		return "", fmt.Errorf("%w &%s;, external entities are not supported", InvalidEntity, e.Name)
+	}
+	for _, x := range expanding {
+		if x == e {
+			return "", fmt.Errorf("%w &%s;, recursive entity", InvalidEntity, e.Name)
+		}
+	}
+	if len(expanding) >= maxEntityDepth {
+		return "", fmt.Errorf("%w, entities nested more than %d levels deep at &%s;", EntityLimit, maxEntityDepth, e.Name)
+	}
+
+	var b strings.Builder
+	if err := dtd.expandText(&b, e.Value, xml11, append(expanding, e)); err != nil {
+		return "", err
+	}
+	if dtd.expanded == nil {
+		dtd.expanded = make(map[*EntityDecl]string)
+	}
+	dtd.expanded[e] = b.String()
+	return b.String(), nil
+}
+
+// expandText writes s into b decoding the entity and character references within it.
+func (dtd *DTD) expandText(b *strings.Builder, s string, xml11 bool, expanding []*EntityDecl) error {
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], ';')
+		if end < 0 {
+			return fmt.Errorf("%w %q, expected ';'", InvalidEntity, s[i:])
+		}
+		name := s[i+1 : i+end]
+		s = s[i+end+1:]
+
+		switch name {
+		case "amp":
+			b.WriteByte('&')
+		case "lt":
+			b.WriteByte('<')
+		case "gt":
+			b.WriteByte('>')
+		case "quot":
+			b.WriteByte('"')
+		case "apos":
+			b.WriteByte('\'')
+		default:
+			if len(name) > 0 && name[0] == '#' {
+				r, ok := parseCharRef([]byte(name[1:]), xml11)
+				if !ok {
+					return fmt.Errorf("%w &%s;", InvalidEntity, name)
+				}
+				b.WriteRune(r)
+				break
+			}
+			e := dtd.entities[name]
+			if e == nil {
+				return fmt.Errorf("%w &%s;, unknown entity", InvalidEntity, name)
+			}
+			text, err := dtd.expand(e, xml11, expanding)
+			if err != nil {
+				return err
+			}
+			b.WriteString(text)
+		}
+		if b.Len() > maxEntitySize {
+			return fmt.Errorf("%w, entity expanded into more than %d bytes", EntityLimit, maxEntitySize)
+		}
+	}
+	if b.Len() > maxEntitySize {
+		return fmt.Errorf("%w, entity expanded into more than %d bytes", EntityLimit, maxEntitySize)
+	}
+	return nil
+}
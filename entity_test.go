@@ -0,0 +1,215 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTokenEntities(t *testing.T) {
+	const input = `<a title="&quot;1&quot; &lt; &#50;">&amp;&lt;&gt;&apos;&quot; &#x20AC;&#8364; &#x1F600;</a>`
+
+	testCases := []struct {
+		desc        string
+		rawEntities bool
+		want        []Token
+	}{
+		{
+			desc: "decoded",
+			want: []Token{
+				&StartTag{Name: &Name{local: "a"}, Attr: []*Attr{{&Name{local: "title"}, `"1" < 2`}}},
+				&CharData{Data: []byte(`&<>'" €€ 😀`)},
+				&CloseTag{&Name{local: "a"}},
+			},
+		},
+		{
+			desc:        "raw",
+			rawEntities: true,
+			want: []Token{
+				&StartTag{Name: &Name{local: "a"}, Attr: []*Attr{{&Name{local: "title"}, "&quot;1&quot; &lt; &#50;"}}},
+				&CharData{Data: []byte("&amp;&lt;&gt;&apos;&quot; &#x20AC;&#8364; &#x1F600;")},
+				&CloseTag{&Name{local: "a"}},
+			},
+		},
+	}
+
+	opts := cmp.Options{
+		cmp.AllowUnexported(Name{}),
+		cmp.Transformer("byteToString", func(in []byte) string { return string(in) }),
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(input))
+			d.RawEntities = tc.rawEntities
+
+			var got []Token
+			for {
+				tok, err := d.Token()
+				if err != nil {
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					t.Fatal(err)
+				}
+				got = append(got, tok.Copy())
+			}
+
+			if diff := cmp.Diff(tc.want, got, opts); diff != "" {
+				t.Error("Token diff (-want +got)\n", diff)
+			}
+		})
+	}
+}
+
+func TestTokenEntityErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  string
+	}{
+		{"unknown", "a &foo; b", "invalid entity &foo;, unknown entity on chardata at row: 1 col: 7"},
+		{"missing semicolon", "a &amp b", "invalid entity &amp, expected ';' but got ' ' on chardata at row: 1 col: 7"},
+		{"stray ampersand", "a & b", "invalid entity &, expected ';' but got ' ' on chardata at row: 1 col: 4"},
+		{"unterminated", "a &amp", "unexpected EOF, unterminated entity &amp on chardata at row: 1 col: 6"},
+		{"too long", "&" + strings.Repeat("a", 40) + ";", "expected ';' but got 'a'"},
+		{"empty", "&;", "invalid entity &;, unknown entity"},
+		{"bad decimal", "&#12a;", "invalid entity &#12a;"},
+		{"bad hex", "&#xZZ;", "invalid entity &#xZZ;"},
+		{"empty reference", "&#;", "invalid entity &#;"},
+		{"negative", "&#-1;", "invalid entity &#-1;"},
+		{"null character", "&#0;", "invalid entity &#0;"},
+		{"control character", "&#x1;", "invalid entity &#x1;"},
+		{"surrogate", "&#xD800;", "invalid entity &#xD800;"},
+		{"out of range", "&#x110000;", "invalid entity &#x110000;"},
+		{"attribute", "\n<a b='&bad;'>", "invalid entity &bad;, unknown entity reading attribute b value on tag <a> at row: 2 col: 11"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if !errors.Is(err, InvalidEntity) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("err: '%s' want InvalidEntity or ErrUnexpectedEOF", err)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenEntityMap(t *testing.T) {
+	const input = `<p title="&product; &copy;">&product;&nbsp;&copy; &amp; &NotEqualTilde; &unknown;</p>`
+
+	testCases := []struct {
+		desc        string
+		entity      map[string]string
+		keepUnknown bool
+		wantAttr    string
+		wantText    string
+		wantErr     string
+	}{
+		{
+			desc:    "unknown error",
+			entity:  HTMLEntity,
+			wantErr: "invalid entity &product;, unknown entity reading attribute title",
+		},
+		{
+			desc:        "html keep unknown",
+			entity:      HTMLEntity,
+			keepUnknown: true,
+			wantAttr:    "&product; ©",
+			wantText:    "&product; © & ≂̸ &unknown;",
+		},
+		{
+			desc:        "custom keep unknown",
+			entity:      map[string]string{"product": "go-xml", "amp": "overridden"},
+			keepUnknown: true,
+			wantAttr:    "go-xml &copy;",
+			wantText:    "go-xml&nbsp;&copy; & &NotEqualTilde; &unknown;",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(input))
+			d.Entity = tc.entity
+			d.KeepUnknownEntities = tc.keepUnknown
+
+			tok, err := d.Token()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err: '%v' want '%s'", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tok.(*StartTag).Attr[0].Value; got != tc.wantAttr {
+				t.Errorf("attribute: %q, want %q", got, tc.wantAttr)
+			}
+			tok, err = d.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(tok.(*CharData).Data); got != tc.wantText {
+				t.Errorf("CharData: %q, want %q", got, tc.wantText)
+			}
+		})
+	}
+}
+
+func TestTokenEntityXML11(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		xml11 bool
+	}{
+		{desc: "option", input: "<a>&#x1;&#31;</a>", xml11: true},
+		{desc: "declaration", input: "<?xml version='1.1'?><a>&#x1;&#31;</a>"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.XML11 = tc.xml11
+			var got []byte
+			for {
+				tok, err := d.Token()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c, ok := tok.(*CharData); ok {
+					got = append(got, c.Data...)
+				}
+			}
+			if want := "\x01\x1f"; string(got) != want {
+				t.Errorf("CharData: %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -90,3 +90,105 @@ func Example_manualDecodingWithTokens() {
 	// Msg{ID: '123', Desc: 'flying mammal', Contents: ' Bat '}
 	// Msg{ID: '456', Desc: 'baseball item', Contents: ' Bat '}
 }
+
+// This example demonstrates how to decode an XML document into a struct using reflection.
+func ExampleUnmarshal() {
+	const data = `
+	<MessageBundle>
+		<Msg>
+			<Source>bat.go</Source>
+			<Source>cave.go</Source>
+			<Text>Bat</Text>
+		</Msg>
+		<Msg>
+			<Source>baseball.go</Source>
+			<Text>Bat</Text>
+		</Msg>
+	</MessageBundle>
+	`
+
+	type Msg struct {
+		Source []string
+		Text   string
+	}
+
+	var bundle struct {
+		Msg []Msg
+	}
+	if err := xml.Unmarshal([]byte(data), &bundle); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, m := range bundle.Msg {
+		fmt.Printf("Msg{Source: %q, Text: '%s'}\n", m.Source, m.Text)
+	}
+
+	// Output:
+	// Msg{Source: ["bat.go" "cave.go"], Text: 'Bat'}
+	// Msg{Source: ["baseball.go"], Text: 'Bat'}
+}
+
+// This example demonstrates how to stream a large document, decoding each element of interest
+// into a struct as soon as its StartTag is found.
+func ExampleDecoder_DecodeElement() {
+	const data = `
+	<messagebundle>
+		<msg id="123" desc="flying mammal">Bat</msg>
+		<msg id="456" desc="baseball item">Bat</msg>
+	</messagebundle>
+	`
+
+	type Msg struct {
+		ID       string `xml:"id,attr"`
+		Desc     string `xml:"desc,attr"`
+		Contents string `xml:",chardata"`
+	}
+
+	d := xml.NewDecoder(strings.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			log.Fatal(err)
+		}
+
+		start, ok := tok.(*xml.StartTag)
+		if !ok || start.Name.Local() != "msg" {
+			continue
+		}
+
+		var m Msg
+		if err := d.DecodeElement(&m, start); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Msg{ID: '%s', Desc: '%s', Contents: '%s'}\n", m.ID, m.Desc, m.Contents)
+	}
+
+	// Output:
+	// Msg{ID: '123', Desc: 'flying mammal', Contents: 'Bat'}
+	// Msg{ID: '456', Desc: 'baseball item', Contents: 'Bat'}
+}
+
+// This example demonstrates how to encode a struct as indented XML.
+func ExampleMarshalIndent() {
+	type Msg struct {
+		XMLName xml.Name `xml:"msg"`
+		ID      string   `xml:"id,attr"`
+		Desc    string   `xml:"desc,attr,omitempty"`
+		Source  []string `xml:"source"`
+		Text    string   `xml:",chardata"`
+	}
+
+	out, err := xml.MarshalIndent(Msg{ID: "123", Source: []string{"bat.go"}, Text: "Bat & Ball"}, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+
+	// Output:
+	// <msg id="123">
+	//   <source>bat.go</source>Bat &amp; Ball
+	// </msg>
+}
@@ -0,0 +1,253 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// htmlToken returns the next token in HTMLMode, emitting the CloseTag of void elements and of the
+// elements closed implicitly, and dropping the end tags without an open element.
+func (d *Decoder) htmlToken() (Token, error) {
+	t := d.pending
+	d.pending = nil
+	for t == nil {
+		// The CloseTag of a void element is implied right after its StartTag.
+		implied := d.selfClosingTag != nil
+		var err error
+		t, err = d.token()
+		if errors.Is(err, io.EOF) && len(d.html) > 0 && hasOptionalEnd(htmlName(d.html[len(d.html)-1])) {
+			return d.implicitClose(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if end, ok := t.(*CloseTag); ok && !implied && !d.isOpen(htmlName(end.Name)) {
+			// HTML parsers drop end tags without an open element, including those of void elements.
+			t = nil
+		}
+	}
+
+	switch t := t.(type) {
+	case *StartTag:
+		name := htmlName(t.Name)
+		if d.endsImplicitly(name) {
+			d.pending = t
+			return d.implicitClose(), nil
+		}
+		d.html = append(d.html, t.Name)
+		if d.selfClosingTag == nil {
+			switch {
+			case isVoidElement(name):
+				d.selfClosingTag = t.Name
+			case name == "script", name == "style":
+				d.rawText = t.Name
+			}
+		}
+	case *CloseTag:
+		name := htmlName(t.Name)
+		for i := len(d.html) - 1; i >= 0; i-- {
+			open := htmlName(d.html[i])
+			if open == name {
+				if i < len(d.html)-1 {
+					// Close the elements left open within this one first.
+					d.pending = t
+					return d.implicitClose(), nil
+				}
+				d.html = d.html[:i]
+				break
+			}
+			if !hasOptionalEnd(open) {
+				break
+			}
+		}
+	}
+	return t, nil
+}
+
+// isOpen reports whether an element named name is currently open.
+func (d *Decoder) isOpen(name string) bool {
+	for _, open := range d.html {
+		if htmlName(open) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// endsImplicitly reports whether a StartTag named start ends the last open element, either directly
+// like <li> ends a previous <li>, or within other elements with an optional end tag like <p>.
+func (d *Decoder) endsImplicitly(start string) bool {
+	for i := len(d.html) - 1; i >= 0; i-- {
+		open := htmlName(d.html[i])
+		if !hasOptionalEnd(open) {
+			return false
+		}
+		if isEndedBy(open, start) {
+			return true
+		}
+	}
+	return false
+}
+
+// implicitClose removes the last open element and returns its CloseTag.
+func (d *Decoder) implicitClose() Token {
+	d.implicitBuf.Name = d.html[len(d.html)-1]
+	d.html = d.html[:len(d.html)-1]
+	return &d.implicitBuf
+}
+
+// readRawText reads the contents of an element like <script> verbatim up to its closing tag, which
+// is read by the following call to token.
+func (d *Decoder) readRawText() (Token, error) {
+	name := []byte(d.rawText.local)
+	d.rawText = nil
+	d.buf.Reset()
+	d.charDataBuf.CDATA = false
+	for {
+		r, err := d.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) && d.buf.Len() > 0 {
+				break
+			}
+			return nil, err
+		}
+		if r == '<' && d.atCloseTag(name) {
+			d.tagPos = Position{Row: d.row + 1, Col: d.col}
+			if d.buf.Len() == 0 {
+				return d.angleStart()
+			}
+			d.startedTag = true
+			break
+		}
+		d.buf.WriteRune(r)
+	}
+	d.charDataBuf.Data = d.buf.Bytes()
+	return &d.charDataBuf, nil
+}
+
+// atCloseTag reports whether the input continues with the rest of a closing tag like `/script>`
+// after a `<`, ignoring case.
+func (d *Decoder) atCloseTag(name []byte) bool {
+	b, _ := d.r.Peek(len(name) + 2)
+	if len(b) < len(name)+1 || b[0] != '/' || !bytes.EqualFold(b[1:len(name)+1], name) {
+		return false
+	}
+	return len(b) == len(name)+1 || b[len(name)+1] == '>' || b[len(name)+1] == '/' || isSpaceByte(b[len(name)+1])
+}
+
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == '\f'
+}
+
+// readUnquoted reads an attribute value without quotes like <foo bar=baz> in HTMLMode, r is its
+// first rune. It returns the whitespace or `>` that ends the value.
+func (d *Decoder) readUnquoted(r rune) (string, rune, error) {
+	d.buf.Reset()
+	for {
+		switch {
+		case unicode.IsSpace(r), r == '>':
+			return d.buf.String(), r, nil
+		case r == '"', r == '\'', r == '<', r == '`':
+			return "", 0, unexpectedChar(r)
+		case r == '&' && !d.RawEntities:
+			if err := d.readEntity(); err != nil {
+				return "", 0, err
+			}
+		default:
+			d.buf.WriteRune(r)
+		}
+		var err error
+		if r, err = d.next(); err != nil {
+			return "", 0, checkUnexpectedEOF(err)
+		}
+	}
+}
+
+// htmlName returns the local name in lower case, or an empty string for names with a namespace
+// prefix since they aren't HTML elements.
+func htmlName(n *Name) string {
+	if n.space != "" {
+		return ""
+	}
+	for i := 0; i < len(n.local); i++ {
+		if c := n.local[i]; c >= 'A' && c <= 'Z' {
+			return strings.ToLower(n.local)
+		}
+	}
+	return n.local
+}
+
+// sameHTMLName reports whether a and b are the same HTML element ignoring case.
+func sameHTMLName(a, b *Name) bool {
+	return a.space == "" && b.space == "" && strings.EqualFold(a.local, b.local)
+}
+
+// isVoidElement reports whether the HTML element never has contents nor a closing tag.
+func isVoidElement(name string) bool {
+	switch name {
+	case "area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "param",
+		"source", "track", "wbr":
+		return true
+	}
+	return false
+}
+
+// hasOptionalEnd reports whether the closing tag of the HTML element may be omitted.
+func hasOptionalEnd(name string) bool {
+	switch name {
+	case "p", "li", "dt", "dd", "option", "optgroup", "thead", "tbody", "tfoot", "tr", "td", "th":
+		return true
+	}
+	return false
+}
+
+// isEndedBy reports whether a StartTag named start implicitly closes the open element.
+func isEndedBy(open, start string) bool {
+	switch open {
+	case "p":
+		return closesParagraph(start)
+	case "li":
+		return start == "li"
+	case "dt", "dd":
+		return start == "dt" || start == "dd"
+	case "option":
+		return start == "option" || start == "optgroup"
+	case "optgroup":
+		return start == "optgroup"
+	case "thead", "tbody":
+		return start == "tbody" || start == "tfoot"
+	case "tr":
+		return start == "tr" || start == "thead" || start == "tbody" || start == "tfoot"
+	case "td", "th":
+		return start == "td" || start == "th" || start == "tr" || start == "thead" || start == "tbody" || start == "tfoot"
+	}
+	return false
+}
+
+// closesParagraph reports whether a StartTag named start implicitly closes an open <p>.
+func closesParagraph(start string) bool {
+	switch start {
+	case "address", "article", "aside", "blockquote", "details", "div", "dl", "fieldset",
+		"figcaption", "figure", "footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header",
+		"hgroup", "hr", "main", "menu", "nav", "ol", "p", "pre", "section", "table", "ul":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// htmlTokens decodes input in HTMLMode and describes the tokens like "<a b=c>", "</a>", or the text.
+func htmlTokens(input string) ([]string, error) {
+	d := NewDecoder(strings.NewReader(input))
+	d.HTMLMode = true
+	d.Strict = true
+	d.Entity = HTMLEntity
+	var got []string
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return got, nil
+		}
+		if err != nil {
+			return got, err
+		}
+		switch tok := tok.(type) {
+		case *StartTag:
+			s := "<" + tok.Name.String()
+			for _, a := range tok.Attr {
+				s += fmt.Sprintf(" %s=%s", a.Name, a.Value)
+			}
+			got = append(got, s+">")
+		case *CloseTag:
+			got = append(got, "</"+tok.Name.String()+">")
+		case *CharData:
+			got = append(got, string(tok.Data))
+		}
+	}
+}
+
+func TestTokenHTMLMode(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  []string
+	}{
+		{
+			desc:  "unquoted attributes",
+			input: `<a href=/x?a=1&amp;b=2 disabled id=y class="z">t</a>`,
+			want:  []string{"<a href=/x?a=1&b=2 disabled= id=y class=z>", "t", "</a>"},
+		},
+		{
+			desc:  "unquoted value ends tag",
+			input: `<img src=a.png><hr/>`,
+			want:  []string{"<img src=a.png>", "</img>", "<hr>", "</hr>"},
+		},
+		{
+			desc:  "void elements",
+			input: `<div>a<br>b<BR>c<input type=text disabled></div>`,
+			want:  []string{"<div>", "a", "<br>", "</br>", "b", "<BR>", "</BR>", "c", "<input type=text disabled=>", "</input>", "</div>"},
+		},
+		{
+			desc:  "void element end tags",
+			input: `<div><br></br><img/></IMG>a</br></div>`,
+			want:  []string{"<div>", "<br>", "</br>", "<img>", "</img>", "a", "</div>"},
+		},
+		{
+			desc:  "self-closing attributes without value",
+			input: `<form><input disabled/><br clear/><hr noshade /></form>`,
+			want:  []string{"<form>", "<input disabled=>", "</input>", "<br clear=>", "</br>", "<hr noshade=>", "</hr>", "</form>"},
+		},
+		{
+			desc:  "stray end tags",
+			input: `</span><div>a</span>b</div></div>`,
+			want:  []string{"<div>", "a", "b", "</div>"},
+		},
+		{
+			desc:  "raw text",
+			input: `<script>if (a < b && c) { x = "</div>"; }</script><STYLE>p > a { }</style ><script></script>`,
+			want: []string{
+				"<script>", `if (a < b && c) { x = "</div>"; }`, "</script>",
+				"<STYLE>", "p > a { }", "</style>",
+				"<script>", "</script>",
+			},
+		},
+		{
+			desc:  "paragraphs",
+			input: `<body><p>a<p>b &nbsp;<div>c</div><p>d</body>`,
+			want:  []string{"<body>", "<p>", "a", "</p>", "<p>", "b  ", "</p>", "<div>", "c", "</div>", "<p>", "d", "</p>", "</body>"},
+		},
+		{
+			desc:  "lists",
+			input: `<ul><li>a<li><p>b<li>c<ul><li>d</ul></ul>`,
+			want: []string{
+				"<ul>", "<li>", "a", "</li>", "<li>", "<p>", "b", "</p>", "</li>", "<li>", "c",
+				"<ul>", "<li>", "d", "</li>", "</ul>", "</li>", "</ul>",
+			},
+		},
+		{
+			desc:  "tables",
+			input: `<table><tr><th>a<td>b<tr><td>c</table>`,
+			want: []string{
+				"<table>", "<tr>", "<th>", "a", "</th>", "<td>", "b", "</td>", "</tr>",
+				"<tr>", "<td>", "c", "</td>", "</tr>", "</table>",
+			},
+		},
+		{
+			desc:  "end of input",
+			input: `<dl><dt>a<dd>b</dl><p>c`,
+			want:  []string{"<dl>", "<dt>", "a", "</dt>", "<dd>", "b", "</dd>", "</dl>", "<p>", "c", "</p>"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := htmlTokens(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Error("Token diff (-want +got)\n", diff)
+			}
+		})
+	}
+}
+
+func TestTokenHTMLModeErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  string
+	}{
+		{"quote in unquoted value", `<a b=c"d>`, `unexpected char '"' reading attribute b value on tag <a>`},
+		{"unclosed element", `<div><p>a`, "unclosed tag <div>"},
+		{"unterminated unquoted value", `<a b=c`, "unexpected EOF reading attribute b value on tag <a>"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := htmlTokens(tc.input)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenAttributesWithoutValue(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`<input disabled checked name="a">`))
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &StartTag{Name: &Name{local: "input"}, Attr: []*Attr{
+		{&Name{local: "disabled"}, ""},
+		{&Name{local: "checked"}, ""},
+		{&Name{local: "name"}, "a"},
+	}}
+	if diff := cmp.Diff(want, tok, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import "strings"
+
+// NameSet is a fixed list of identifiers that the names returned by a Decoder are matched against,
+// which is how code generated by go-xml-gen finds the field of every element and attribute.
+//
+//    var msgNames = xml.NewNameSet("msg", "id", "source")
+//
+//    switch d.NameIndex(msgNames, tag.Name) {
+//    case 1: ...
+//    }
+type NameSet struct {
+	names []*Name
+}
+
+// NewNameSet instantiates a NameSet with identifiers written like in XML, either "local" or
+// "space:local".
+func NewNameSet(idents ...string) *NameSet {
+	s := &NameSet{names: make([]*Name, len(idents))}
+	for i, ident := range idents {
+		s.names[i] = splitName(ident)
+	}
+	return s
+}
+
+// Names returns the names of the set in the same order given to NewNameSet. These names are not
+// interned by any Decoder, but can be used to build tokens for `Encoder.EncodeToken`.
+//
+// The returned slice must not be modified.
+func (s *NameSet) Names() []*Name {
+	return s.names
+}
+
+// index returns the position of the first name in the set matching name, or -1. Names without
+// namespace match any namespace like struct tags do.
+func (s *NameSet) index(name *Name) int {
+	for i, n := range s.names {
+		if n.local == name.local && name.inSpace(n.space) {
+			return i
+		}
+	}
+	return -1
+}
+
+// nameIndexKey identifies a name interned by a Decoder within a NameSet.
+type nameIndexKey struct {
+	set  *NameSet
+	name *Name
+}
+
+// NameIndex returns the position in the set of the first identifier matching name, or -1 if there
+// is none. Identifiers without namespace match any namespace, like struct tags do.
+//
+// Generated code calls it for every tag and attribute, so the result is kept for each set and *Name
+// pointer, making repeated calls with a name returned by this Decoder a single map lookup.
+func (d *Decoder) NameIndex(s *NameSet, name *Name) int {
+	key := nameIndexKey{s, name}
+	if i, ok := d.nameIndex.get(key); ok {
+		return i.(int)
+	}
+	i := s.index(name)
+	d.nameIndex.put(key, i)
+	return i
+}
+
+// internName returns the Name interned by the Decoder for the identifier written like "local" or
+// "space:local".
+func (d *Decoder) internName(ident []byte) *Name {
+	name, found := d.nameTable().Intern(ident)
+	if !found {
+		d.nameCount++
+	}
+	return name
+}
+
+// nameTable returns the NameTable set by the user, or the one owned by the Decoder.
+func (d *Decoder) nameTable() NameTable {
+	if d.NameTable != nil {
+		return d.NameTable
+	}
+	if d.names == nil {
+		d.names = NewNameTable()
+	}
+	return d.names
+}
+
+// NameStats returns the counters of the NameTable used by the Decoder.
+func (d *Decoder) NameStats() NameStats {
+	return d.nameTable().Stats()
+}
+
+// splitName creates a Name from an identifier written like "local" or "space:local".
+func splitName(ident string) *Name {
+	if i := strings.IndexByte(ident, ':'); i >= 0 {
+		return &Name{space: ident[:i], local: ident[i+1:]}
+	}
+	return &Name{local: ident}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNameIndex(t *testing.T) {
+	set := NewNameSet("id", "x:note", "note")
+	d := NewDecoder(strings.NewReader(`<a id="1" y:id="2" x:note="3" note="4" y:note="5" other="6">`))
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{0, 0, 1, 2, 2, -1}
+	attrs := tok.(*StartTag).Attr
+	if len(attrs) != len(want) {
+		t.Fatalf("got %d attributes, want %d", len(attrs), len(want))
+	}
+	for i, a := range attrs {
+		// Twice to verify the cached result.
+		for j := 0; j < 2; j++ {
+			if got := d.NameIndex(set, a.Name); got != want[i] {
+				t.Errorf("NameIndex(%s) = %d, want %d", a.Name, got, want[i])
+			}
+		}
+	}
+}
+
+func TestReadText(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`<a>x<b>skipped</b>y</a><c>z</c>`))
+	for _, want := range []string{"xy", "z"} {
+		if _, err := d.Token(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.ReadText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("ReadText: %q, want %q", got, want)
+		}
+	}
+}
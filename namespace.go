@@ -0,0 +1,140 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import "fmt"
+
+const (
+	// xmlURI is the namespace bound to the `xml` prefix, like in `xml:space`.
+	xmlURI = "http://www.w3.org/XML/1998/namespace"
+
+	// xmlnsURI is the namespace of the `xmlns` attributes that declare namespaces.
+	xmlnsURI = "http://www.w3.org/2000/xmlns/"
+)
+
+// nsBinding is a namespace declared by an `xmlns:prefix` attribute, or `xmlns` for the default
+// namespace with an empty prefix.
+type nsBinding struct {
+	prefix string
+	uri    string
+}
+
+// nsNameKey identifies a name resolved to a namespace URI. It holds the identifier rather than the
+// interned *Name, which a bounded NameTable may allocate again.
+type nsNameKey struct {
+	space, local, uri string
+}
+
+// pushNamespaces declares the namespaces in the attributes of start for the scope of the element,
+// then resolves the names of the tag and its attributes.
+func (d *Decoder) pushNamespaces(start *StartTag) error {
+	d.nsMarks = append(d.nsMarks, len(d.ns))
+	for _, a := range start.Attr {
+		switch {
+		case a.Name.space == "" && a.Name.local == "xmlns":
+			if a.Value == xmlURI || a.Value == xmlnsURI {
+				return fmt.Errorf("%w, %q can't be the default namespace", InvalidNamespace, a.Value)
+			}
+			d.ns = append(d.ns, nsBinding{uri: a.Value})
+		case a.Name.space == "xmlns":
+			if err := checkBinding(a.Name.local, a.Value); err != nil {
+				return err
+			}
+			d.ns = append(d.ns, nsBinding{prefix: a.Name.local, uri: a.Value})
+		}
+	}
+
+	name, err := d.resolveName(start.Name, true)
+	if err != nil {
+		return err
+	}
+	start.Name = name
+	for _, a := range start.Attr {
+		if a.Name, err = d.resolveName(a.Name, false); err != nil {
+			return fmt.Errorf("%w on tag <%s>", err, start.Name)
+		}
+	}
+	return nil
+}
+
+// checkBinding validates the declaration of prefix to uri.
+func checkBinding(prefix, uri string) error {
+	switch {
+	case prefix == "xmlns":
+		return fmt.Errorf("%w, the xmlns prefix can't be declared", InvalidNamespace)
+	case prefix == "xml" && uri != xmlURI, prefix != "xml" && uri == xmlURI:
+		return fmt.Errorf("%w, the xml prefix must be bound to %q", InvalidNamespace, xmlURI)
+	case uri == xmlnsURI:
+		return fmt.Errorf("%w, %q can't be bound to a prefix", InvalidNamespace, uri)
+	case uri == "":
+		return fmt.Errorf("%w, the %s prefix can't be bound to an empty URI", InvalidNamespace, prefix)
+	}
+	return nil
+}
+
+// popNamespaces removes the namespaces declared by the element being closed.
+func (d *Decoder) popNamespaces() {
+	if len(d.nsMarks) == 0 {
+		return
+	}
+	d.ns = d.ns[:d.nsMarks[len(d.nsMarks)-1]]
+	d.nsMarks = d.nsMarks[:len(d.nsMarks)-1]
+}
+
+// resolveName returns the interned name with the namespace URI that its prefix is bound to. Names
+// without prefix are only in the default namespace when isElement is set, attributes without prefix
+// have no namespace.
+func (d *Decoder) resolveName(name *Name, isElement bool) (*Name, error) {
+	var uri string
+	switch {
+	case name.space == "xml":
+		uri = xmlURI
+	case name.space == "xmlns", name.space == "" && name.local == "xmlns" && !isElement:
+		uri = xmlnsURI
+	case name.space == "" && !isElement:
+		return name, nil
+	default:
+		found := false
+		for i := len(d.ns) - 1; i >= 0; i-- {
+			if d.ns[i].prefix == name.space {
+				uri, found = d.ns[i].uri, true
+				break
+			}
+		}
+		if !found && name.space != "" {
+			return nil, fmt.Errorf("%w %q for %s", UndeclaredPrefix, name.space, name)
+		}
+	}
+	if uri == "" {
+		// The default namespace is undeclared, or was reset with xmlns="".
+		return name, nil
+	}
+
+	key := nsNameKey{name.space, name.local, uri}
+	if n, ok := d.nsNames[key]; ok {
+		return n, nil
+	}
+	n := &Name{local: name.local, space: name.space, uri: uri}
+	if d.nsNames == nil {
+		d.nsNames = make(map[nsNameKey]*Name)
+	}
+	// Resolved names are never evicted so they can be compared by pointer, MaxNames bounds them.
+	d.nsNames[key] = n
+	d.nameCount++
+	if err := d.checkNames(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTokenNamespaces(t *testing.T) {
+	const (
+		soap = "http://schemas.xmlsoap.org/soap/envelope/"
+		atom = "http://www.w3.org/2005/Atom"
+	)
+	const input = `<soap:Envelope xmlns:soap="` + soap + `" xmlns="urn:a">` +
+		`<soap:Body soap:id="1" id="2"><feed xmlns="` + atom + `" xml:lang="en"><title/></feed>` +
+		`<title/><p:title xmlns:p="urn:p"/><title xmlns=""/></soap:Body></soap:Envelope>`
+	d := NewDecoder(strings.NewReader(input))
+	d.Namespaces = true
+
+	envelope := &Name{space: "soap", local: "Envelope", uri: soap}
+	body := &Name{space: "soap", local: "Body", uri: soap}
+	feed := &Name{local: "feed", uri: atom}
+	want := []Token{
+		&StartTag{Name: envelope, Attr: []*Attr{
+			{&Name{space: "xmlns", local: "soap", uri: xmlnsURI}, soap},
+			{&Name{local: "xmlns", uri: xmlnsURI}, "urn:a"},
+		}},
+		&StartTag{Name: body, Attr: []*Attr{
+			{&Name{space: "soap", local: "id", uri: soap}, "1"},
+			{&Name{local: "id"}, "2"},
+		}},
+		&StartTag{Name: feed, Attr: []*Attr{
+			{&Name{local: "xmlns", uri: xmlnsURI}, atom},
+			{&Name{space: "xml", local: "lang", uri: xmlURI}, "en"},
+		}},
+		&StartTag{Name: &Name{local: "title", uri: atom}},
+		&CloseTag{&Name{local: "title", uri: atom}},
+		&CloseTag{feed},
+		&StartTag{Name: &Name{local: "title", uri: "urn:a"}},
+		&CloseTag{&Name{local: "title", uri: "urn:a"}},
+		&StartTag{Name: &Name{space: "p", local: "title", uri: "urn:p"}, Attr: []*Attr{
+			{&Name{space: "xmlns", local: "p", uri: xmlnsURI}, "urn:p"},
+		}},
+		&CloseTag{&Name{space: "p", local: "title", uri: "urn:p"}},
+		&StartTag{Name: &Name{local: "title"}, Attr: []*Attr{{&Name{local: "xmlns", uri: xmlnsURI}, ""}}},
+		&CloseTag{&Name{local: "title"}},
+		&CloseTag{body},
+		&CloseTag{envelope},
+	}
+
+	var got []Token
+	titles := map[string]*Name{}
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start, ok := tok.(*StartTag); ok && start.Name.Local() == "title" {
+			if prev, ok := titles[start.Name.URI()]; ok && prev != start.Name {
+				t.Errorf("name %s in %q was not interned", start.Name, start.Name.URI())
+			}
+			titles[start.Name.URI()] = start.Name
+		}
+		got = append(got, tok.Copy())
+	}
+
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("Token diff (-want +got)\n", diff)
+	}
+	if name := got[0].(*StartTag).Name; name.Space() != "soap" || name.URI() != soap {
+		t.Errorf("Space(): %q URI(): %q, want %q and %q", name.Space(), name.URI(), "soap", soap)
+	}
+	if titles[atom] == titles["urn:a"] {
+		t.Error("names in different namespaces must not be the same pointer")
+	}
+}
+
+func TestTokenNamespacesManyNames(t *testing.T) {
+	// Resolve more distinct names than the Decoder caches in between, x:a must keep its pointer.
+	var input strings.Builder
+	input.WriteString(`<x:root xmlns:x="urn:x"><x:a/>`)
+	for i := 0; i < 2*maxCachedNames; i++ {
+		fmt.Fprintf(&input, "<x:n%d/>", i)
+	}
+	input.WriteString(`<x:a/></x:root>`)
+	d := NewDecoder(strings.NewReader(input.String()))
+	d.Namespaces = true
+	d.NameTable = NewLRUNameTable(16)
+
+	var a *Name
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, ok := tok.(*StartTag)
+		if !ok || start.Name.Local() != "a" {
+			continue
+		}
+		if a != nil && start.Name != a {
+			t.Fatalf("name %s was not interned", start.Name)
+		}
+		a = start.Name
+	}
+}
+
+func TestTokenNamespaceErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		want  error
+		msg   string
+	}{
+		{"undeclared tag", "<a:b/>", UndeclaredPrefix, `undeclared namespace prefix "a" for a:b at row: 1 col: 1`},
+		{"undeclared attribute", "<b a:c='1'/>", UndeclaredPrefix, `undeclared namespace prefix "a" for a:c on tag <b> at row: 1 col: 1`},
+		{"out of scope", "<r><a:b xmlns:a='urn:a'/><a:b/></r>", UndeclaredPrefix, `undeclared namespace prefix "a" for a:b at row: 1 col: 26`},
+		{"empty prefix URI", "<a:b xmlns:a=''/>", InvalidNamespace, "the a prefix can't be bound to an empty URI"},
+		{"xmlns prefix", "<b xmlns:xmlns='urn:a'/>", InvalidNamespace, "the xmlns prefix can't be declared"},
+		{"xml prefix", "<b xmlns:xml='urn:a'/>", InvalidNamespace, `the xml prefix must be bound to "` + xmlURI + `"`},
+		{"xmlns URI", "<b xmlns:a='" + xmlnsURI + "'/>", InvalidNamespace, `"` + xmlnsURI + `" can't be bound to a prefix`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.Namespaces = true
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("err: '%v' want %v", err, tc.want)
+			}
+			if !strings.Contains(err.Error(), tc.msg) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.msg)
+			}
+		})
+	}
+}
+
+func TestUnmarshalNamespaces(t *testing.T) {
+	type entry struct {
+		XMLName Name   `xml:"http://www.w3.org/2005/Atom entry"`
+		Title   string `xml:"http://www.w3.org/2005/Atom title"`
+		Other   string `xml:"urn:other title"`
+		Lang    string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	}
+	const input = `<entry xmlns="http://www.w3.org/2005/Atom" xmlns:o="urn:other" xml:lang="en">` +
+		`<o:title>other</o:title><title>atom</title></entry>`
+
+	d := NewDecoder(strings.NewReader(input))
+	d.Namespaces = true
+	var got entry
+	if err := d.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := entry{
+		XMLName: Name{local: "entry", uri: "http://www.w3.org/2005/Atom"},
+		Title:   "atom",
+		Other:   "other",
+		Lang:    "en",
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("Decode diff (-want +got)\n", diff)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"container/list"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/google/triemap"
+)
+
+// NameTable interns the tag, attribute, and processing instruction names read by a Decoder, so the
+// same identifier is usually returned as the same *Name pointer and only allocated once. Names
+// returned by a table must never be modified.
+//
+// Tables are not safe for concurrent use unless stated otherwise, but they can be reused by
+// decoders one after another, see Decoder.NameTable.
+type NameTable interface {
+	// Intern returns the Name for the identifier written like "local" or "space:local", and reports
+	// whether it was already in the table. The identifier is only valid during the call, so tables
+	// must copy it to keep it, which they should only do when it's not found.
+	Intern(ident []byte) (*Name, bool)
+
+	// Stats returns the counters of the table.
+	Stats() NameStats
+}
+
+// NameStats are the counters of a NameTable.
+type NameStats struct {
+	// Hits and Misses count the calls to Intern that found the name in the table or not.
+	Hits   int64
+	Misses int64
+
+	// Size is the number of names in the table, and Evicted the number of names removed from it to
+	// make room for others.
+	Size    int
+	Evicted int64
+}
+
+// trieNameTable is the unbounded NameTable, it keeps every name it ever interned.
+type trieNameTable struct {
+	names triemap.RuneSliceMap
+	// runes is the key of the last identifier looked up, reused so lookups don't allocate.
+	runes []rune
+	stats NameStats
+}
+
+// NewNameTable returns a NameTable that keeps every name it interns, which is what a Decoder uses
+// unless Decoder.NameTable is set. It's the fastest table, but its memory grows with every distinct
+// name in the input.
+func NewNameTable() NameTable {
+	return &trieNameTable{}
+}
+
+func (t *trieNameTable) Intern(ident []byte) (*Name, bool) {
+	t.runes = t.runes[:0]
+	for b := ident; len(b) > 0; {
+		r, size := utf8.DecodeRune(b)
+		t.runes = append(t.runes, r)
+		b = b[size:]
+	}
+	name, ok := t.names.Get(t.runes)
+	// The trie also reports prefixes of other names as found, but without a value.
+	if ok && name != nil {
+		t.stats.Hits++
+		return name.(*Name), true
+	}
+	n := splitName(string(ident))
+	t.names.Put(t.runes, n)
+	t.stats.Misses++
+	t.stats.Size++
+	return n, false
+}
+
+func (t *trieNameTable) Stats() NameStats {
+	return t.stats
+}
+
+// lruNameTable keeps the names used most recently, see NewLRUNameTable.
+type lruNameTable struct {
+	names map[string]*list.Element
+	lru   lru
+	stats NameStats
+}
+
+// NewLRUNameTable returns a NameTable that keeps up to max names, evicting the least recently used
+// name to make room for a new one. An evicted name is allocated again the next time it's interned,
+// so its pointer changes and it counts again toward Decoder.MaxNames.
+func NewLRUNameTable(max int) NameTable {
+	return &lruNameTable{
+		names: make(map[string]*list.Element),
+		lru:   lru{max: max},
+	}
+}
+
+func (t *lruNameTable) Intern(ident []byte) (*Name, bool) {
+	if e, ok := t.names[string(ident)]; ok {
+		t.stats.Hits++
+		return t.lru.get(e).(*Name), true
+	}
+	t.stats.Misses++
+	key := string(ident)
+	n := splitName(key)
+	if t.lru.max <= 0 {
+		return n, false
+	}
+	e, evicted := t.lru.add(key, n)
+	if evicted != nil {
+		delete(t.names, evicted.(string))
+		t.stats.Evicted++
+	}
+	t.names[key] = e
+	t.stats.Size = len(t.names)
+	return n, false
+}
+
+func (t *lruNameTable) Stats() NameStats {
+	return t.stats
+}
+
+// cappedNameTable keeps the first names it interns, see NewCappedNameTable.
+type cappedNameTable struct {
+	max   int
+	names map[string]*Name
+	stats NameStats
+}
+
+// NewCappedNameTable returns a NameTable that keeps the first max names it interns. Once it's full,
+// new names are allocated every time they're interned without being kept.
+func NewCappedNameTable(max int) NameTable {
+	return &cappedNameTable{max: max, names: make(map[string]*Name)}
+}
+
+func (t *cappedNameTable) Intern(ident []byte) (*Name, bool) {
+	if n, ok := t.names[string(ident)]; ok {
+		t.stats.Hits++
+		return n, true
+	}
+	t.stats.Misses++
+	key := string(ident)
+	n := splitName(key)
+	if len(t.names) < t.max {
+		t.names[key] = n
+		t.stats.Size = len(t.names)
+	}
+	return n, false
+}
+
+func (t *cappedNameTable) Stats() NameStats {
+	return t.stats
+}
+
+// sharedNameTable is a read-only NameTable, see NewSharedNameTable.
+type sharedNameTable struct {
+	// The counters are first so they are aligned for atomic operations on 32-bit platforms.
+	hits   int64
+	misses int64
+	names  map[string]*Name
+}
+
+// NewSharedNameTable returns a read-only NameTable with the given identifiers, written like "local"
+// or "space:local". Other names are allocated every time they're interned without being kept.
+//
+// Unlike other tables, it's safe for concurrent use, so it can be shared by every Decoder of a
+// program that reads documents with a known vocabulary.
+func NewSharedNameTable(idents ...string) NameTable {
+	t := &sharedNameTable{names: make(map[string]*Name, len(idents))}
+	for _, ident := range idents {
+		t.names[ident] = splitName(ident)
+	}
+	return t
+}
+
+func (t *sharedNameTable) Intern(ident []byte) (*Name, bool) {
+	if n, ok := t.names[string(ident)]; ok {
+		atomic.AddInt64(&t.hits, 1)
+		return n, true
+	}
+	atomic.AddInt64(&t.misses, 1)
+	return splitName(string(ident)), false
+}
+
+func (t *sharedNameTable) Stats() NameStats {
+	return NameStats{
+		Hits:   atomic.LoadInt64(&t.hits),
+		Misses: atomic.LoadInt64(&t.misses),
+		Size:   len(t.names),
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNameTables(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		table NameTable
+		want  NameStats
+	}{
+		{"unbounded", NewNameTable(), NameStats{Hits: 4, Misses: 3, Size: 3}},
+		{"LRU", NewLRUNameTable(2), NameStats{Hits: 3, Misses: 4, Size: 2, Evicted: 2}},
+		{"capped", NewCappedNameTable(2), NameStats{Hits: 4, Misses: 3, Size: 2}},
+		{"shared", NewSharedNameTable("a", "x:b"), NameStats{Hits: 6, Misses: 1, Size: 2}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			// Tables must not keep the identifier, the Decoder reuses its buffer.
+			buf := []byte("a")
+			first, _ := tc.table.Intern(buf)
+			for _, ident := range []string{"x:b", "a", "c", "a", "x:b"} {
+				buf = append(buf[:0], ident...)
+				tc.table.Intern(buf)
+			}
+			if got, found := tc.table.Intern([]byte("a")); !found || got != first {
+				t.Errorf("Intern(a): %v, %t, want the first pointer", got, found)
+			}
+			if got := tc.table.Stats(); got != tc.want {
+				t.Errorf("Stats(): %+v want %+v", got, tc.want)
+			}
+			if got, _ := tc.table.Intern([]byte("x:b")); got.Space() != "x" || got.Local() != "b" {
+				t.Errorf("Intern(x:b): %#v", got)
+			}
+		})
+	}
+}
+
+func TestDecoderNameTable(t *testing.T) {
+	const input = `<messagebundle>
+  <msg id="1" desc="flying mammal"><source>a.go</source>Bat<ph name="x"><ex>ex</ex></ph>cave</msg>
+  <msg id="2" desc="baseball item"><source>b.go</source>Bat</msg>
+</messagebundle>`
+
+	var want xmbBundle
+	if err := Unmarshal([]byte(input), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	shared := NewSharedNameTable("messagebundle", "msg", "id")
+	testCases := []struct {
+		desc  string
+		table func() NameTable
+	}{
+		{"LRU", func() NameTable { return NewLRUNameTable(1) }},
+		{"capped", func() NameTable { return NewCappedNameTable(0) }},
+		{"shared", func() NameTable { return shared }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			// Decoders can share the table, only one at a time unless it's shared.
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				table := tc.table()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d := NewDecoder(strings.NewReader(input))
+					d.NameTable = table
+					var got xmbBundle
+					if err := d.Decode(&got); err != nil {
+						t.Error(err)
+						return
+					}
+					if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+						t.Error("Decode diff (-want +got)\n", diff)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+
+	// Every document has 8 names in the table, counting close tags, and 11 others.
+	if got := shared.Stats(); got.Hits != 4*8 || got.Misses != 4*11 {
+		t.Errorf("shared Stats(): %+v", got)
+	}
+}
+
+func TestDecoderNameStats(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`<a b="1"><a b="2"/><c/></a>`))
+	for {
+		if _, err := d.Token(); err != nil {
+			break
+		}
+	}
+	// The close tag </a> is a hit too.
+	want := NameStats{Hits: 3, Misses: 3, Size: 3}
+	if got := d.NameStats(); got != want {
+		t.Errorf("NameStats(): %+v want %+v", got, want)
+	}
+}
+
+func TestInternNameAllocs(t *testing.T) {
+	d := NewDecoder(strings.NewReader(""))
+	ident := []byte("x:msg")
+	d.internName(ident)
+	// Names already in the table are found without copying the identifier.
+	if n := testing.AllocsPerRun(100, func() { d.internName(ident) }); n != 0 {
+		t.Errorf("internName allocs: %v want 0", n)
+	}
+}
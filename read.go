@@ -0,0 +1,591 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Unmarshaler is the interface implemented by objects that can unmarshal an XML element
+// description of themselves.
+//
+// DecodeXML decodes a single XML element beginning with the given StartTag, it must consume exactly
+// one element, usually by calling `d.DecodeElement` or by reading tokens with `d.Token` until the
+// matching CloseTag. Since tokens are reused by the Decoder, the Name and Attr of start must be read
+// before consuming more tokens.
+//
+// If it returns an error, the outer call to Unmarshal stops and returns that error.
+type Unmarshaler interface {
+	DecodeXML(d *Decoder, start *StartTag) error
+}
+
+// UnmarshalerAttr is the interface implemented by objects that can unmarshal an XML attribute
+// description of themselves.
+//
+// DecodeXMLAttr is called for fields tagged with `attr`. The attribute is not modified by the
+// Decoder afterwards, so it may be retained.
+type UnmarshalerAttr interface {
+	DecodeXMLAttr(attr *Attr) error
+}
+
+var (
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	unmarshalerAttrType = reflect.TypeOf((*UnmarshalerAttr)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Unmarshal parses the XML-encoded data and stores the result in the value pointed to by v, which
+// must be a pointer to a struct, slice, or basic type.
+//
+// Types implementing `Unmarshaler` or `encoding.TextUnmarshaler` decode themselves, and attribute
+// fields may implement `UnmarshalerAttr` or `encoding.TextUnmarshaler` instead.
+//
+// Exported struct fields are matched against child elements using the field name, and basic types
+// are filled with the element's CharData. Child elements that don't match any field are skipped.
+//
+// The field mapping can be customized with `xml` struct tags, similar to `encoding/xml`:
+//
+//    XMLName Name   `xml:"msg"`           Checks and records the element name.
+//    ID      string `xml:"id,attr"`        Reads the attribute named "id".
+//    Text    string `xml:",chardata"`      Accumulates the CharData of the element.
+//    Inner   string `xml:",innerxml"`      Records the raw XML within the element.
+//    Note    string `xml:",comment"`       Accumulates the contents of the comments.
+//    Other   []T    `xml:",any"`           Receives the child elements not matched by other fields.
+//    Attrs   []Attr `xml:",any,attr"`      Receives the attributes not matched by other fields.
+//    Deep    string `xml:"a>b>c"`          Reads the element <c> nested within <a><b>.
+//    Ignored string `xml:"-"`              Is never read.
+//
+// The `omitempty` modifier is accepted and ignored when decoding. A tag name may be preceded by a
+// namespace prefix and a space, like `xml:"ns name"`.
+//
+// See `Decoder.Decode` for details.
+func Unmarshal(data []byte, v interface{}) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Decode reads the next XML element from the input and stores it in the value pointed to by v.
+//
+// Tokens before the first StartTag are discarded. When v is a slice, a new element is appended to
+// it for every call to Decode.
+func (d *Decoder) Decode(v interface{}) error {
+	return d.DecodeElement(v, nil)
+}
+
+// DecodeElement works like Decode except that it takes a pointer to the StartTag that was already
+// read from the input, so only the rest of that element is consumed. If start is nil, the next
+// StartTag is read from the input like Decode does.
+//
+// This is useful to stream large documents, where the caller uses `Decoder.Token` to find the
+// elements of interest and decodes each of them on its own.
+func (d *Decoder) DecodeElement(v interface{}, start *StartTag) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return errors.New("non-pointer passed to Unmarshal")
+	}
+	if val.IsNil() {
+		return errors.New("nil pointer passed to Unmarshal")
+	}
+	return d.unmarshal(val.Elem(), start)
+}
+
+// unmarshal stores a single XML element into val.
+func (d *Decoder) unmarshal(val reflect.Value, start *StartTag) error {
+	// Find the start tag.
+	for start == nil {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if t, ok := tok.(*StartTag); ok {
+			start = t
+		}
+	}
+
+	// Load value from interface, but only if the result will be usefully addressable.
+	if val.Kind() == reflect.Interface && !val.IsNil() {
+		e := val.Elem()
+		if e.Kind() == reflect.Ptr && !e.IsNil() {
+			val = e
+		}
+	}
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+
+	if val.CanInterface() && val.Type().Implements(unmarshalerType) {
+		// This is an unmarshaler with a non-pointer receiver, so it's likely to be incorrect, but we
+		// do what we're told.
+		return d.unmarshalInterface(val.Interface().(Unmarshaler), start)
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(unmarshalerType) {
+			return d.unmarshalInterface(pv.Interface().(Unmarshaler), start)
+		}
+	}
+	if u, ok := textUnmarshaler(val); ok {
+		return d.unmarshalTextInterface(u, start)
+	}
+
+	switch v := val; v.Kind() {
+	case reflect.Interface:
+		// Nothing we can do with an empty interface, skip the element.
+		return d.Skip()
+	case reflect.Slice:
+		typ := v.Type()
+		if typ.Elem().Kind() == reflect.Uint8 {
+			// []byte
+			break
+		}
+
+		// Slice of element values. Grow slice.
+		n := v.Len()
+		v.Set(reflect.Append(v, reflect.Zero(typ.Elem())))
+
+		// Recur to read element into slice.
+		if err := d.unmarshal(v.Index(n), start); err != nil {
+			v.SetLen(n)
+			return err
+		}
+		return nil
+	case reflect.Struct:
+		return d.unmarshalStruct(v, start)
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+	default:
+		return fmt.Errorf("cannot unmarshal into %s", v.Type())
+	}
+	return d.unmarshalScalar(val, start)
+}
+
+// unmarshalInterface calls the Unmarshaler and verifies that it consumed exactly the element that
+// begins with start.
+func (d *Decoder) unmarshalInterface(val Unmarshaler, start *StartTag) error {
+	name := start.Name
+	depth := d.depth
+	if err := val.DecodeXML(d, start); err != nil {
+		return err
+	}
+	switch {
+	case d.depth > depth-1:
+		return fmt.Errorf("%T.DecodeXML did not consume entire <%s> element", val, name)
+	case d.depth < depth-1:
+		return fmt.Errorf("%T.DecodeXML consumed past the end of <%s> element", val, name)
+	}
+	return nil
+}
+
+// unmarshalTextInterface calls the TextUnmarshaler with the CharData contents of start. Child
+// elements are skipped.
+func (d *Decoder) unmarshalTextInterface(val encoding.TextUnmarshaler, start *StartTag) error {
+	name := start.Name
+	if err := d.readCharData(); err != nil {
+		return err
+	}
+	if err := val.UnmarshalText(d.valueBuf); err != nil {
+		return fmt.Errorf("%w reading <%s>", err, name.Local())
+	}
+	return nil
+}
+
+// textUnmarshaler returns the encoding.TextUnmarshaler implemented by val or its address.
+func textUnmarshaler(val reflect.Value) (encoding.TextUnmarshaler, bool) {
+	if val.CanInterface() && val.Type().Implements(textUnmarshalerType) {
+		return val.Interface().(encoding.TextUnmarshaler), true
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(textUnmarshalerType) {
+			return pv.Interface().(encoding.TextUnmarshaler), true
+		}
+	}
+	return nil, false
+}
+
+// copyText stores CharData into dst, using encoding.TextUnmarshaler when dst implements it.
+func copyText(dst reflect.Value, src []byte) error {
+	if u, ok := textUnmarshaler(dst); ok {
+		return u.UnmarshalText(src)
+	}
+	return copyValue(dst, src)
+}
+
+// unmarshalStruct fills the struct fields with the attributes and contents of start.
+func (d *Decoder) unmarshalStruct(sv reflect.Value, start *StartTag) error {
+	tinfo, err := getTypeInfo(sv.Type())
+	if err != nil {
+		return err
+	}
+	name := start.Name
+
+	// Validate and assign element name.
+	if tinfo.xmlname != nil {
+		finfo := tinfo.xmlname
+		if finfo.name != "" && !finfo.matches(name) {
+			return fmt.Errorf("expected element <%s> but have <%s>", finfo.name, name.Local())
+		}
+		fv := finfo.value(sv)
+		if fv.Type() == namePtrType {
+			fv.Set(reflect.ValueOf(name))
+		} else {
+			fv.Set(reflect.ValueOf(*name))
+		}
+	}
+
+	// Assign attributes. They must be processed before reading the next token because the StartTag
+	// instance is reused by the Decoder.
+	for _, a := range start.Attr {
+		for _, i := range d.lookupFields(tinfo, a.Name, fAttr) {
+			if err := d.unmarshalAttr(tinfo.fields[i].value(sv), a); err != nil {
+				return fmt.Errorf("%w reading attribute %s on <%s>", err, a.Name.Local(), name.Local())
+			}
+		}
+	}
+
+	// Determine whether we need to save character data, comments, or the raw inner XML.
+	var saveData, saveComment, saveXML, saveAny reflect.Value
+	if tinfo.chardata >= 0 {
+		saveData = tinfo.fields[tinfo.chardata].value(sv)
+	}
+	if tinfo.comment >= 0 {
+		saveComment = tinfo.fields[tinfo.comment].value(sv)
+	}
+	if tinfo.any >= 0 {
+		saveAny = tinfo.fields[tinfo.any].value(sv)
+	}
+	if tinfo.innerxml >= 0 {
+		saveXML = tinfo.fields[tinfo.innerxml].value(sv)
+	}
+
+	// Comment contents are only read when requested.
+	if saveComment.IsValid() && !d.ReadComment {
+		d.ReadComment = true
+		defer func() { d.ReadComment = false }()
+	}
+
+	var saveXMLStart int
+	if saveXML.IsValid() {
+		if d.saved == nil {
+			d.savedBuf.Reset()
+			d.saved = &d.savedBuf
+			defer func() { d.saved = nil }()
+		}
+		saveXMLStart = d.saved.Len()
+	}
+
+	var data, comment []byte
+	for {
+		var saveXMLEnd int
+		if saveXML.IsValid() {
+			saveXMLEnd = d.saved.Len()
+			if d.startedTag {
+				// The '<' was already consumed along with the previous CharData token.
+				saveXMLEnd--
+			}
+		}
+
+		tok, err := d.Token()
+		if err != nil {
+			return checkUnexpectedEOF(err)
+		}
+		switch t := tok.(type) {
+		case *StartTag:
+			var consumed bool
+			if i := d.lookupField(tinfo, t.Name); i >= 0 {
+				if finfo := &tinfo.fields[i]; len(finfo.parents) == 0 {
+					consumed, err = true, d.unmarshal(finfo.value(sv), t)
+				} else {
+					consumed, err = d.unmarshalPath(tinfo, sv, nil, t)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%w in <%s>", err, name.Local())
+			}
+			if !consumed && saveAny.IsValid() {
+				consumed = true
+				if err := d.unmarshal(saveAny, t); err != nil {
+					return fmt.Errorf("%w in <%s>", err, name.Local())
+				}
+			}
+			if !consumed {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case *CharData:
+			if saveData.IsValid() {
+				data = append(data, t.Data...)
+			}
+		case *Comment:
+			if saveComment.IsValid() {
+				comment = append(comment, t.Data...)
+			}
+		case *CloseTag:
+			if saveData.IsValid() {
+				if err := copyText(saveData, data); err != nil {
+					return fmt.Errorf("%w reading <%s>", err, name.Local())
+				}
+			}
+			if saveComment.IsValid() {
+				if err := copyValue(saveComment, comment); err != nil {
+					return fmt.Errorf("%w reading comment in <%s>", err, name.Local())
+				}
+			}
+			if saveXML.IsValid() {
+				if err := copyValue(saveXML, d.saved.Bytes()[saveXMLStart:saveXMLEnd]); err != nil {
+					return fmt.Errorf("%w reading <%s>", err, name.Local())
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// unmarshalPath walks down an XML structure looking for wanted paths like `xml:"a>b>c"`, and calls
+// unmarshal on them.
+//
+// The consumed result tells whether XML elements have been consumed from the Decoder until start's
+// matching end element, or if it's still untouched because start is uninteresting for sv's fields.
+func (d *Decoder) unmarshalPath(tinfo *typeInfo, sv reflect.Value, parents []string, start *StartTag) (consumed bool, err error) {
+	recurse := false
+Loop:
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fElement == 0 || len(finfo.parents) < len(parents) || !start.Name.inSpace(finfo.xmlns) {
+			continue
+		}
+		for j := range parents {
+			if parents[j] != finfo.parents[j] {
+				continue Loop
+			}
+		}
+		if len(finfo.parents) == len(parents) && finfo.name == start.Name.local {
+			// It's a perfect match, unmarshal the field.
+			return true, d.unmarshal(finfo.value(sv), start)
+		}
+		if len(finfo.parents) > len(parents) && finfo.parents[len(parents)] == start.Name.local {
+			// It's a prefix for the field. Break and recurse since it's not ok for one field path to
+			// be itself the prefix for another field path.
+			recurse = true
+
+			// We can reuse the same slice as long as we don't try to append to it.
+			parents = finfo.parents[:len(parents)+1]
+			break
+		}
+	}
+	if !recurse {
+		// We have no business with this element.
+		return false, nil
+	}
+
+	// The element is not a perfect match for any field, but one or more fields have the path to
+	// this element as a parent prefix. Recurse and attempt to match these.
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return true, checkUnexpectedEOF(err)
+		}
+		switch t := tok.(type) {
+		case *StartTag:
+			consumed, err := d.unmarshalPath(tinfo, sv, parents, t)
+			if err != nil {
+				return true, err
+			}
+			if !consumed {
+				if err := d.Skip(); err != nil {
+					return true, err
+				}
+			}
+		case *CloseTag:
+			return true, nil
+		}
+	}
+}
+
+// unmarshalAttr stores the attribute value into val.
+func (d *Decoder) unmarshalAttr(val reflect.Value, attr *Attr) error {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+
+	if val.CanInterface() && val.Type().Implements(unmarshalerAttrType) {
+		// This is an unmarshaler with a non-pointer receiver, so it's likely to be incorrect, but we
+		// do what we're told.
+		return val.Interface().(UnmarshalerAttr).DecodeXMLAttr(attr)
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(unmarshalerAttrType) {
+			return pv.Interface().(UnmarshalerAttr).DecodeXMLAttr(attr)
+		}
+	}
+	if u, ok := textUnmarshaler(val); ok {
+		return u.UnmarshalText([]byte(attr.Value))
+	}
+
+	switch {
+	case val.Type() == attrType:
+		val.Set(reflect.ValueOf(*attr))
+		return nil
+	case val.Kind() == reflect.String:
+		// Attribute values are already new strings, avoid copying them through copyValue.
+		val.SetString(attr.Value)
+		return nil
+	case val.Kind() == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8:
+		// Slice of element values. Grow slice.
+		n := val.Len()
+		val.Set(reflect.Append(val, reflect.Zero(val.Type().Elem())))
+
+		// Recur to read element into slice.
+		if err := d.unmarshalAttr(val.Index(n), attr); err != nil {
+			val.SetLen(n)
+			return err
+		}
+		return nil
+	}
+	return copyValue(val, []byte(attr.Value))
+}
+
+// unmarshalScalar reads the CharData contents of start into val. Child elements are skipped.
+func (d *Decoder) unmarshalScalar(val reflect.Value, start *StartTag) error {
+	name := start.Name
+	if err := d.readCharData(); err != nil {
+		return err
+	}
+	if err := copyValue(val, d.valueBuf); err != nil {
+		return fmt.Errorf("%w reading <%s>", err, name.Local())
+	}
+	return nil
+}
+
+// ReadText reads the CharData of the element whose StartTag was just returned by Token, consuming
+// everything until its CloseTag. Child elements are skipped.
+//
+// The returned slice is reused by the Decoder, it's only valid until the next call to ReadText or
+// Decode.
+func (d *Decoder) ReadText() ([]byte, error) {
+	if err := d.readCharData(); err != nil {
+		return nil, err
+	}
+	return d.valueBuf, nil
+}
+
+// readCharData accumulates the CharData of the current element into d.valueBuf until its CloseTag
+// is consumed. Child elements are skipped.
+func (d *Decoder) readCharData() error {
+	d.valueBuf = d.valueBuf[:0]
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return checkUnexpectedEOF(err)
+		}
+		switch t := tok.(type) {
+		case *StartTag:
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case *CharData:
+			d.valueBuf = append(d.valueBuf, t.Data...)
+		case *CloseTag:
+			return nil
+		}
+	}
+}
+
+// Skip reads tokens until it has consumed the CloseTag matching the most recent StartTag already
+// consumed, skipping nested structures.
+func (d *Decoder) Skip() error {
+	var depth int
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return checkUnexpectedEOF(err)
+		}
+		switch tok.(type) {
+		case *StartTag:
+			depth++
+		case *CloseTag:
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		}
+	}
+}
+
+// copyValue parses src into dst according to dst's kind.
+func copyValue(dst reflect.Value, src []byte) error {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if len(src) == 0 {
+			dst.SetInt(0)
+			return nil
+		}
+		itmp, err := strconv.ParseInt(string(bytes.TrimSpace(src)), 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetInt(itmp)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if len(src) == 0 {
+			dst.SetUint(0)
+			return nil
+		}
+		utmp, err := strconv.ParseUint(string(bytes.TrimSpace(src)), 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetUint(utmp)
+	case reflect.Float32, reflect.Float64:
+		if len(src) == 0 {
+			dst.SetFloat(0)
+			return nil
+		}
+		ftmp, err := strconv.ParseFloat(string(bytes.TrimSpace(src)), dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(ftmp)
+	case reflect.Bool:
+		if len(src) == 0 {
+			dst.SetBool(false)
+			return nil
+		}
+		value, err := strconv.ParseBool(string(bytes.TrimSpace(src)))
+		if err != nil {
+			return err
+		}
+		dst.SetBool(value)
+	case reflect.String:
+		dst.SetString(string(src))
+	case reflect.Slice:
+		// non-nil to flag presence
+		dst.SetBytes(append(make([]byte, 0, len(src)), src...))
+	}
+	return nil
+}
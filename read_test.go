@@ -0,0 +1,591 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	stdxml "encoding/xml"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type unmarshalInner struct {
+	Value string
+	Count int
+}
+
+type unmarshalOuter struct {
+	Name   string
+	Ptr    *int
+	Flag   bool
+	Ratio  float64
+	Small  uint8
+	Raw    []byte
+	Inner  unmarshalInner
+	Items  []unmarshalInner
+	Tags   []string
+	Ignore interface{}
+	hidden string
+}
+
+func TestUnmarshal(t *testing.T) {
+	const input = `
+	<outer>
+		<Name>go-xml</Name>
+		<Unknown><Name>skipped</Name></Unknown>
+		<Ptr> 42 </Ptr>
+		<Flag>true</Flag>
+		<Ratio>0.5</Ratio>
+		<Small>255</Small>
+		<Raw>bytes</Raw>
+		<Inner><Value>a</Value><Count>1</Count></Inner>
+		<Items><Value>b</Value><Count>2</Count></Items>
+		<Items><Value>c</Value><Count>3</Count><Extra/></Items>
+		<Tags>x</Tags>
+		<Tags>y<Nested>z</Nested></Tags>
+		<Ignore>nothing</Ignore>
+		<hidden>secret</hidden>
+	</outer>
+	`
+	ptr := 42
+	want := unmarshalOuter{
+		Name:  "go-xml",
+		Ptr:   &ptr,
+		Flag:  true,
+		Ratio: 0.5,
+		Small: 255,
+		Raw:   []byte("bytes"),
+		Inner: unmarshalInner{Value: "a", Count: 1},
+		Items: []unmarshalInner{{Value: "b", Count: 2}, {Value: "c", Count: 3}},
+		Tags:  []string{"x", "y"},
+	}
+
+	var got unmarshalOuter
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(unmarshalOuter{})); diff != "" {
+		t.Error("Unmarshal diff (-want +got)\n", diff)
+	}
+}
+
+func TestDecodeSlice(t *testing.T) {
+	const input = `<a>1</a><b>2</b><c>3</c>`
+	d := NewDecoder(strings.NewReader(input))
+
+	var got []int
+	for {
+		err := d.Decode(&got)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff([]int{1, 2, 3}, got); diff != "" {
+		t.Error("Decode diff (-want +got)\n", diff)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var i int
+	testCases := []struct {
+		desc  string
+		input string
+		v     interface{}
+		want  string
+	}{
+		{"non-pointer", "<a/>", i, "non-pointer passed to Unmarshal"},
+		{"nil pointer", "<a/>", (*int)(nil), "nil pointer passed to Unmarshal"},
+		{"unsupported", "<a/>", new(map[string]string), "cannot unmarshal into map[string]string"},
+		{"bad int", "<a>b</a>", new(int), `parsing "b": invalid syntax reading <a>`},
+		{"nested bad int", "<a><Count>b</Count></a>", new(unmarshalInner), "reading <Count> in <a>"},
+		{"unclosed", "<a><Value>b</Value>", new(unmarshalInner), "unexpected EOF"},
+		{"empty", "", new(int), "EOF"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.input), tc.v)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSkip(t *testing.T) {
+	const input = `<a><b><c/></b>text<b/></a><d/>`
+	d := NewDecoder(strings.NewReader(input))
+	if _, err := d.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := d.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := tok.(*StartTag); !ok || got.Name.Local() != "d" {
+		t.Errorf("Token after Skip: %#v, want <d>", tok)
+	}
+}
+
+type xmbPlaceholder struct {
+	Name    string `xml:"name,attr"`
+	Example string `xml:"ex"`
+}
+
+type xmbMsg struct {
+	XMLName *Name            `xml:"msg"`
+	ID      string           `xml:"id,attr"`
+	Desc    string           `xml:"desc,attr"`
+	Meaning string           `xml:"meaning,attr,omitempty"`
+	Source  []string         `xml:"source"`
+	Ph      []xmbPlaceholder `xml:"ph"`
+	Text    string           `xml:",chardata"`
+	Inner   string           `xml:",innerxml"`
+}
+
+type xmbBundle struct {
+	XMLName Name     `xml:"messagebundle"`
+	Msgs    []xmbMsg `xml:"msg"`
+}
+
+func TestUnmarshalXMB(t *testing.T) {
+	const input = `<messagebundle>
+  <msg id="1" desc="flying mammal" meaning="animal"><source>a.go</source><source>b.go</source>Bat<ph name="x"><ex>ex</ex></ph>cave</msg>
+  <msg id="2" desc="baseball item"/>
+</messagebundle>`
+
+	want := xmbBundle{
+		XMLName: Name{local: "messagebundle"},
+		Msgs: []xmbMsg{
+			{
+				XMLName: &Name{local: "msg"},
+				ID:      "1",
+				Desc:    "flying mammal",
+				Meaning: "animal",
+				Source:  []string{"a.go", "b.go"},
+				Ph:      []xmbPlaceholder{{Name: "x", Example: "ex"}},
+				Text:    "Batcave",
+				Inner:   `<source>a.go</source><source>b.go</source>Bat<ph name="x"><ex>ex</ex></ph>cave`,
+			},
+			{
+				XMLName: &Name{local: "msg"},
+				ID:      "2",
+				Desc:    "baseball item",
+			},
+		},
+	}
+
+	var got xmbBundle
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("Unmarshal diff (-want +got)\n", diff)
+	}
+}
+
+type unmarshalEmbedded struct {
+	Embedded string `xml:"embedded"`
+}
+
+type unmarshalModifiers struct {
+	unmarshalEmbedded
+	Deep     []string `xml:"a>b>c"`
+	Sibling  string   `xml:"a>d"`
+	Comment  string   `xml:",comment"`
+	Any      []string `xml:",any"`
+	Attr     int      `xml:"attr,attr"`
+	AnyAttr  []Attr   `xml:",any,attr"`
+	Ignored  string   `xml:"-"`
+	Explicit *string  `xml:"explicit"`
+}
+
+// sharedAttr has two fields matching x:id, only ID is restricted to the namespace.
+type sharedAttr struct {
+	ID    string `xml:"urn:x id,attr"`
+	Num   int    `xml:"id,attr"`
+	Other string `xml:"other,attr"`
+}
+
+func TestDecodeLikeEncodingXML(t *testing.T) {
+	corpus, err := ioutil.ReadFile("testdata/bench.xmb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc       string
+		input      string
+		namespaces bool
+		v          func() interface{}
+	}{
+		{
+			desc:  "corpus",
+			input: string(corpus),
+			v:     func() interface{} { return new(benchBundle) },
+		},
+		{
+			desc:       "attributes sharing a name",
+			input:      `<a xmlns:x="urn:x" x:id="12" other="x"/>`,
+			namespaces: true,
+			v:          func() interface{} { return new(sharedAttr) },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			want := tc.v()
+			if err := stdxml.Unmarshal([]byte(tc.input), want); err != nil {
+				t.Fatal(err)
+			}
+			// Decode twice with the same Decoder so the second pass uses the cached field lookups.
+			d := NewDecoder(strings.NewReader(tc.input + tc.input))
+			d.Namespaces = tc.namespaces
+			for i := 0; i < 2; i++ {
+				got := tc.v()
+				if err := d.Decode(got); err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Fatal("Decode diff vs encoding/xml (-want +got)\n", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalModifiers(t *testing.T) {
+	const input = `<root attr="7" other="x" more="y">
+		<embedded>e</embedded>
+		<a><b><c>1</c><c>2</c></b><d>sibling</d><ignored/></a>
+		<!--first--><!--second-->
+		<z>any1</z><y>any2</y>
+		<Ignored>no</Ignored>
+		<explicit>yes</explicit>
+	</root>`
+
+	explicit := "yes"
+	want := unmarshalModifiers{
+		unmarshalEmbedded: unmarshalEmbedded{Embedded: "e"},
+		Deep:              []string{"1", "2"},
+		Sibling:           "sibling",
+		Comment:           "firstsecond",
+		Any:               []string{"any1", "any2", "no"},
+		Attr:              7,
+		AnyAttr: []Attr{
+			{Name: &Name{local: "other"}, Value: "x"},
+			{Name: &Name{local: "more"}, Value: "y"},
+		},
+		Explicit: &explicit,
+	}
+
+	d := NewDecoder(strings.NewReader(input))
+	var got unmarshalModifiers
+	if err := d.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{}, unmarshalModifiers{})); diff != "" {
+		t.Error("Decode diff (-want +got)\n", diff)
+	}
+	if d.ReadComment {
+		t.Error("ReadComment was left enabled after decoding a comment field")
+	}
+}
+
+func TestUnmarshalTagErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		v     interface{}
+		want  string
+	}{
+		{
+			desc:  "wrong element",
+			input: "<foo/>",
+			v:     new(xmbMsg),
+			want:  "expected element <msg> but have <foo>",
+		},
+		{
+			desc:  "bad attribute",
+			input: `<a attr="x"/>`,
+			v:     new(unmarshalModifiers),
+			want:  "reading attribute attr on <a>",
+		},
+		{
+			desc:  "invalid flags",
+			input: "<a/>",
+			v: new(struct {
+				A string `xml:"a,attr,chardata"`
+			}),
+			want: `invalid tag in field A`,
+		},
+		{
+			desc:  "trailing path",
+			input: "<a/>",
+			v: new(struct {
+				A string `xml:"a>"`
+			}),
+			want: "trailing '>' in field A",
+		},
+		{
+			desc:  "conflict",
+			input: "<a/>",
+			v: new(struct {
+				A string `xml:"a>b"`
+				B string `xml:"a"`
+			}),
+			want: `field "A" with tag "a>b" conflicts with field "B" with tag "a"`,
+		},
+		{
+			desc:  "bad XMLName",
+			input: "<a/>",
+			v: new(struct {
+				XMLName string
+			}),
+			want: "field XMLName of type struct { XMLName string } must be of type Name or *Name",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.input), tc.v)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeElement(t *testing.T) {
+	const input = `<messagebundle>
+		<header><msg id="ignored"/></header>
+		<msg id="1" desc="a"><source>a.go</source></msg>
+		<msg id="2" desc="b"/>
+	</messagebundle>`
+
+	want := []xmbMsg{
+		{XMLName: &Name{local: "msg"}, ID: "1", Desc: "a", Source: []string{"a.go"}, Inner: "<source>a.go</source>"},
+		{XMLName: &Name{local: "msg"}, ID: "2", Desc: "b"},
+	}
+
+	d := NewDecoder(strings.NewReader(input))
+	var got []xmbMsg
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, ok := tok.(*StartTag)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local() {
+		case "header":
+			if err := d.Skip(); err != nil {
+				t.Fatal(err)
+			}
+		case "msg":
+			var msg xmbMsg
+			if err := d.DecodeElement(&msg, start); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, msg)
+		}
+	}
+
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("DecodeElement diff (-want +got)\n", diff)
+	}
+}
+
+// placeholder decodes itself from the tokens, like `<ph name="x"><ex>example</ex></ph>`.
+type placeholder struct {
+	Name     string
+	Contents string
+}
+
+func (p *placeholder) DecodeXML(d *Decoder, start *StartTag) error {
+	// start is reused by the Decoder, keep the name to find the matching CloseTag.
+	name := start.Name
+	for _, attr := range start.Attr {
+		if attr.Name.Local() == "name" {
+			p.Name = strings.ToUpper(attr.Value)
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case *CharData:
+			p.Contents += string(tok.Data)
+		case *CloseTag:
+			if tok.Name == name {
+				return nil
+			}
+		}
+	}
+}
+
+// upperAttr decodes an attribute as an upper case string.
+type upperAttr string
+
+func (u *upperAttr) DecodeXMLAttr(attr *Attr) error {
+	*u = upperAttr(strings.ToUpper(attr.Value))
+	return nil
+}
+
+// csv decodes and encodes comma separated values from text.
+type csv []string
+
+func (c csv) MarshalText() ([]byte, error) {
+	return []byte(strings.Join(c, ",")), nil
+}
+
+func (c *csv) UnmarshalText(text []byte) error {
+	*c = strings.Split(string(text), ",")
+	return nil
+}
+
+type unmarshalInterfaces struct {
+	ID      upperAttr     `xml:"id,attr"`
+	Tags    csv           `xml:"tags,attr"`
+	Created time.Time     `xml:"created"`
+	Ph      []placeholder `xml:"ph"`
+	PhPtr   *placeholder  `xml:"ptr"`
+	Text    csv           `xml:",chardata"`
+}
+
+func TestUnmarshalInterfaces(t *testing.T) {
+	const input = `<msg id="abc" tags="a,b">
+		<created>2020-06-19T01:46:22Z</created>
+		<ph name="x"><ex>foo</ex></ph>
+		<ph name="y">bar</ph>
+		<ptr name="z"/>
+	</msg>`
+
+	want := unmarshalInterfaces{
+		ID:      "ABC",
+		Tags:    csv{"a", "b"},
+		Created: time.Date(2020, 6, 19, 1, 46, 22, 0, time.UTC),
+		Ph:      []placeholder{{Name: "X", Contents: "foo"}, {Name: "Y", Contents: "bar"}},
+		PhPtr:   &placeholder{Name: "Z"},
+		Text:    csv{"     "},
+	}
+
+	var got unmarshalInterfaces
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Unmarshal diff (-want +got)\n", diff)
+	}
+}
+
+// partialUnmarshaler doesn't consume the whole element.
+type partialUnmarshaler struct{}
+
+func (partialUnmarshaler) DecodeXML(d *Decoder, start *StartTag) error {
+	_, err := d.Token()
+	return err
+}
+
+// greedyUnmarshaler consumes one token past the element.
+type greedyUnmarshaler struct{}
+
+func (greedyUnmarshaler) DecodeXML(d *Decoder, start *StartTag) error {
+	for i := 0; i < 2; i++ {
+		if _, err := d.Token(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUnmarshalInterfaceErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		v     interface{}
+		want  string
+	}{
+		{
+			desc:  "partial",
+			input: "<a><b/></a>",
+			v:     new(partialUnmarshaler),
+			want:  "xml.partialUnmarshaler.DecodeXML did not consume entire <a> element",
+		},
+		{
+			desc:  "past the end",
+			input: "<r><a/></r>",
+			v: new(struct {
+				A greedyUnmarshaler `xml:"a"`
+			}),
+			want: "xml.greedyUnmarshaler.DecodeXML consumed past the end of <a> element",
+		},
+		{
+			desc:  "text",
+			input: "<a>yesterday</a>",
+			v:     new(time.Time),
+			want:  `cannot parse "yesterday" as "2006" reading <a>`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := Unmarshal([]byte(tc.input), tc.v)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	const input = `<messagebundle><msg id="1"><source>a.go</msg></messagebundle>`
+
+	d := NewDecoder(strings.NewReader(input))
+	d.Strict = true
+	var got xmbBundle
+	err := d.Decode(&got)
+	var mismatch *TagMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("err: '%v' want TagMismatchError", err)
+	}
+	if mismatch.Start.Local() != "source" || mismatch.Close.Local() != "msg" {
+		t.Errorf("mismatch: <%s> and </%s>, want <source> and </msg>", mismatch.Start, mismatch.Close)
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// typeInfo holds details for the xml representation of a type.
+type typeInfo struct {
+	xmlname *fieldInfo
+	fields  []fieldInfo
+
+	// Indexes into fields of the first field with each special mode, or -1 if there is none. They are
+	// computed once so decoding a struct doesn't have to scan every field.
+	chardata int
+	comment  int
+	innerxml int
+	any      int
+	anyAttr  int
+}
+
+// fieldInfo holds details for the xml representation of a single field.
+type fieldInfo struct {
+	idx     []int
+	name    string
+	xmlns   string
+	flags   fieldFlags
+	parents []string
+
+	// ident is the Name used when encoding the field.
+	ident *Name
+}
+
+type fieldFlags int
+
+const (
+	fElement fieldFlags = 1 << iota
+	fAttr
+	fCharData
+	fInnerXML
+	fComment
+	fAny
+
+	fOmitEmpty
+
+	fMode = fElement | fAttr | fCharData | fInnerXML | fComment | fAny
+
+	xmlName = "XMLName"
+)
+
+var (
+	nameType    = reflect.TypeOf(Name{})
+	namePtrType = reflect.TypeOf(&Name{})
+	attrType    = reflect.TypeOf(Attr{})
+)
+
+var tinfoMap sync.Map // map[reflect.Type]*typeInfo
+
+// getTypeInfo returns the typeInfo structure with details necessary for marshaling and
+// unmarshaling typ. The result is cached, so the reflection work is done only once for every type.
+func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
+	if ti, ok := tinfoMap.Load(typ); ok {
+		return ti.(*typeInfo), nil
+	}
+	tinfo, err := buildTypeInfo(typ)
+	if err != nil {
+		return nil, err
+	}
+	tinfo.indexModes()
+	ti, _ := tinfoMap.LoadOrStore(typ, tinfo)
+	return ti.(*typeInfo), nil
+}
+
+// buildTypeInfo reads the fields and tags of typ, embedded structs are read using getTypeInfo.
+func buildTypeInfo(typ reflect.Type) (*typeInfo, error) {
+	tinfo := &typeInfo{}
+	if typ.Kind() != reflect.Struct || typ == nameType {
+		return tinfo, nil
+	}
+	n := typ.NumField()
+	for i := 0; i < n; i++ {
+		f := typ.Field(i)
+		if (f.PkgPath != "" && !f.Anonymous) || f.Tag.Get("xml") == "-" {
+			continue // Private field
+		}
+
+		// For embedded structs, embed its fields.
+		if f.Anonymous {
+			t := f.Type
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Struct {
+				inner, err := getTypeInfo(t)
+				if err != nil {
+					return nil, err
+				}
+				if tinfo.xmlname == nil {
+					tinfo.xmlname = inner.xmlname
+				}
+				for _, finfo := range inner.fields {
+					finfo.idx = append([]int{i}, finfo.idx...)
+					if err := tinfo.addFieldInfo(typ, &finfo); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if f.PkgPath != "" {
+				continue // Private embedded non-struct type
+			}
+		}
+
+		finfo, err := structFieldInfo(typ, &f)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.Name == xmlName {
+			tinfo.xmlname = finfo
+			continue
+		}
+
+		// Add the field if it doesn't conflict with other fields.
+		if err := tinfo.addFieldInfo(typ, finfo); err != nil {
+			return nil, err
+		}
+	}
+	return tinfo, nil
+}
+
+// structFieldInfo builds and returns a fieldInfo for f.
+//
+// The tag has the format `xml:"[namespace ]name[,flag...]"` where the name may be a path like
+// "a>b>c" for elements nested within the struct element.
+func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, error) {
+	finfo := &fieldInfo{idx: f.Index}
+
+	// Split the tag from the xml namespace if necessary.
+	tag := f.Tag.Get("xml")
+	if i := strings.Index(tag, " "); i >= 0 {
+		finfo.xmlns, tag = tag[:i], tag[i+1:]
+	}
+
+	// Parse flags.
+	tokens := strings.Split(tag, ",")
+	if len(tokens) == 1 {
+		finfo.flags = fElement
+	} else {
+		tag = tokens[0]
+		for _, flag := range tokens[1:] {
+			switch flag {
+			case "attr":
+				finfo.flags |= fAttr
+			case "chardata":
+				finfo.flags |= fCharData
+			case "innerxml":
+				finfo.flags |= fInnerXML
+			case "comment":
+				finfo.flags |= fComment
+			case "any":
+				finfo.flags |= fAny
+			case "omitempty":
+				finfo.flags |= fOmitEmpty
+			}
+		}
+
+		// Validate the flags used.
+		valid := true
+		switch mode := finfo.flags & fMode; mode {
+		case 0:
+			finfo.flags |= fElement
+		case fAttr, fCharData, fInnerXML, fComment, fAny, fAny | fAttr:
+			if f.Name == xmlName || tag != "" && mode != fAttr {
+				valid = false
+			}
+		default:
+			// This will also catch multiple modes in a single field.
+			valid = false
+		}
+		if finfo.flags&fMode == fAny {
+			finfo.flags |= fElement
+		}
+		if finfo.flags&fOmitEmpty != 0 && finfo.flags&(fElement|fAttr) == 0 {
+			valid = false
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid tag in field %s of type %s: %q", f.Name, typ, f.Tag.Get("xml"))
+		}
+	}
+
+	// Use of xmlns without a name is not allowed.
+	if finfo.xmlns != "" && tag == "" {
+		return nil, fmt.Errorf("namespace without name in field %s of type %s: %q", f.Name, typ, f.Tag.Get("xml"))
+	}
+
+	if f.Name == xmlName {
+		// The XMLName field records the XML element name. Don't modify finfo.name, only the tag
+		// can set the element name.
+		finfo.name = tag
+		if f.Type != nameType && f.Type != namePtrType {
+			return nil, fmt.Errorf("field %s of type %s must be of type Name or *Name", f.Name, typ)
+		}
+		if tag != "" {
+			finfo.ident = &Name{space: finfo.xmlns, local: tag}
+		}
+		return finfo, nil
+	}
+
+	if tag == "" {
+		// Use the field name as the element or attribute name.
+		tag = f.Name
+	}
+
+	// Prepare field name and parents.
+	parents := strings.Split(tag, ">")
+	if parents[0] == "" {
+		parents[0] = f.Name
+	}
+	if parents[len(parents)-1] == "" {
+		return nil, fmt.Errorf("trailing '>' in field %s of type %s", f.Name, typ)
+	}
+	finfo.name = parents[len(parents)-1]
+	if len(parents) > 1 {
+		if (finfo.flags & fElement) == 0 {
+			return nil, fmt.Errorf("%s chain not valid with %s flag", tag, strings.Join(tokens[1:], ","))
+		}
+		finfo.parents = parents[:len(parents)-1]
+	}
+	finfo.ident = &Name{space: finfo.xmlns, local: finfo.name}
+	return finfo, nil
+}
+
+// addFieldInfo adds finfo to tinfo.fields if there are no conflicts, or if conflicts arise from
+// previous fields that were obtained from deeper embedded structures than finfo. In the latter
+// case, the conflicting entries are dropped.
+func (tinfo *typeInfo) addFieldInfo(typ reflect.Type, newf *fieldInfo) error {
+	var conflicts []int
+	// First, figure all conflicts. Most working code will have none.
+Loop:
+	for i := range tinfo.fields {
+		oldf := &tinfo.fields[i]
+		if oldf.flags&fMode != newf.flags&fMode {
+			continue
+		}
+		if oldf.xmlns != "" && newf.xmlns != "" && oldf.xmlns != newf.xmlns {
+			continue
+		}
+		minl := len(newf.parents)
+		if len(oldf.parents) < minl {
+			minl = len(oldf.parents)
+		}
+		for p := 0; p < minl; p++ {
+			if oldf.parents[p] != newf.parents[p] {
+				continue Loop
+			}
+		}
+		switch {
+		case len(oldf.parents) > len(newf.parents):
+			if oldf.parents[len(newf.parents)] == newf.name {
+				conflicts = append(conflicts, i)
+			}
+		case len(oldf.parents) < len(newf.parents):
+			if newf.parents[len(oldf.parents)] == oldf.name {
+				conflicts = append(conflicts, i)
+			}
+		default:
+			if newf.name == oldf.name && newf.xmlns == oldf.xmlns {
+				conflicts = append(conflicts, i)
+			}
+		}
+	}
+
+	// Without conflicts, add the new field and return.
+	if conflicts == nil {
+		tinfo.fields = append(tinfo.fields, *newf)
+		return nil
+	}
+
+	// If any conflict is shallower, ignore the new field. This matches the Go field resolution on
+	// embedding.
+	for _, i := range conflicts {
+		if len(tinfo.fields[i].idx) < len(newf.idx) {
+			return nil
+		}
+	}
+
+	// Otherwise, if any of them is at the same depth level, it's an error.
+	for _, i := range conflicts {
+		oldf := &tinfo.fields[i]
+		if len(oldf.idx) == len(newf.idx) {
+			f1 := typ.FieldByIndex(oldf.idx)
+			f2 := typ.FieldByIndex(newf.idx)
+			return fmt.Errorf("%s field %q with tag %q conflicts with field %q with tag %q",
+				typ, f1.Name, f1.Tag.Get("xml"), f2.Name, f2.Tag.Get("xml"))
+		}
+	}
+
+	// Otherwise, the new field is shallower, and thus takes precedence, so drop the conflicting
+	// fields from tinfo and append the new one.
+	for c := len(conflicts) - 1; c >= 0; c-- {
+		i := conflicts[c]
+		copy(tinfo.fields[i:], tinfo.fields[i+1:])
+		tinfo.fields = tinfo.fields[:len(tinfo.fields)-1]
+	}
+	tinfo.fields = append(tinfo.fields, *newf)
+	return nil
+}
+
+// indexModes records the first field for every special mode, the first one wins like it would when
+// scanning the fields in order.
+func (tinfo *typeInfo) indexModes() {
+	tinfo.chardata, tinfo.comment, tinfo.innerxml, tinfo.any, tinfo.anyAttr = -1, -1, -1, -1, -1
+	for i := range tinfo.fields {
+		var idx *int
+		switch tinfo.fields[i].flags & fMode {
+		case fCharData:
+			idx = &tinfo.chardata
+		case fComment:
+			idx = &tinfo.comment
+		case fInnerXML:
+			idx = &tinfo.innerxml
+		case fAny, fAny | fElement:
+			idx = &tinfo.any
+		case fAny | fAttr:
+			idx = &tinfo.anyAttr
+		default:
+			continue
+		}
+		if *idx == -1 {
+			*idx = i
+		}
+	}
+}
+
+// findAttrs returns the indexes of every attribute field matching name, or of the `,any,attr` field
+// when none does.
+func (tinfo *typeInfo) findAttrs(name *Name) []int {
+	var idx []int
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fMode == fAttr && finfo.matches(name) {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 && tinfo.anyAttr >= 0 {
+		idx = append(idx, tinfo.anyAttr)
+	}
+	return idx
+}
+
+// findElement returns the index of the first element field that is either named like name or
+// nested within an element named like it, or -1.
+func (tinfo *typeInfo) findElement(name *Name) int {
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fElement == 0 || !name.inSpace(finfo.xmlns) {
+			continue
+		}
+		if len(finfo.parents) == 0 && finfo.name == name.local ||
+			len(finfo.parents) > 0 && finfo.parents[0] == name.local {
+			return i
+		}
+	}
+	return -1
+}
+
+// fieldKey identifies a name interned by a Decoder within the fields of a type.
+type fieldKey struct {
+	tinfo *typeInfo
+	name  *Name
+	mode  fieldFlags
+}
+
+// lookupField returns the index of the element field in tinfo matching name, or -1.
+func (d *Decoder) lookupField(tinfo *typeInfo, name *Name) int {
+	if idx := d.lookupFields(tinfo, name, fElement); len(idx) > 0 {
+		return idx[0]
+	}
+	return -1
+}
+
+// lookupFields returns the indexes of the fields in tinfo matching name, mode is either fAttr or
+// fElement. Every attribute field matching name is returned, but only the first element field.
+// Decoding many elements of the same type reads the same names over and over, so matches are kept
+// per type, mode, and *Name pointer instead of walking the fields again.
+func (d *Decoder) lookupFields(tinfo *typeInfo, name *Name, mode fieldFlags) []int {
+	key := fieldKey{tinfo, name, mode}
+	if idx, ok := d.fields.get(key); ok {
+		return idx.([]int)
+	}
+	var idx []int
+	if mode == fAttr {
+		idx = tinfo.findAttrs(name)
+	} else if i := tinfo.findElement(name); i >= 0 {
+		idx = []int{i}
+	}
+	d.fields.put(key, idx)
+	return idx
+}
+
+// matches reports whether name is the identifier described by the field.
+func (finfo *fieldInfo) matches(name *Name) bool {
+	return finfo.name == name.local && name.inSpace(finfo.xmlns)
+}
+
+// value returns v's field value corresponding to finfo. It's equivalent to v.FieldByIndex(finfo.idx),
+// but initializes and dereferences pointers as necessary.
+func (finfo *fieldInfo) value(v reflect.Value) reflect.Value {
+	for i, x := range finfo.idx {
+		if i > 0 {
+			t := v.Type()
+			if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+				if v.IsNil() {
+					v.Set(reflect.New(v.Type().Elem()))
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// valueIfSet returns v's field value corresponding to finfo like value does, but returns an invalid
+// reflect.Value instead of initializing nil pointers to embedded structs.
+func (finfo *fieldInfo) valueIfSet(v reflect.Value) reflect.Value {
+	for i, x := range finfo.idx {
+		if i > 0 {
+			t := v.Type()
+			if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+				if v.IsNil() {
+					return reflect.Value{}
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
@@ -0,0 +1,503 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError is returned when Validate is set and the document doesn't follow its DTD. Name and
+// Pos refer to the element that breaks the rules, or that holds the attribute breaking them.
+type ValidationError struct {
+	Name   *Name
+	Pos    Position
+	Reason string
+}
+
+func (err *ValidationError) Error() string {
+	if err.Name == nil {
+		return fmt.Sprintf("invalid document at %s: %s", err.Pos, err.Reason)
+	}
+	return fmt.Sprintf("invalid <%s> at %s: %s", err.Name, err.Pos, err.Reason)
+}
+
+// validator checks the tokens returned by a Decoder against its DTD, see Decoder.Validate.
+type validator struct {
+	dtd *DTD
+
+	// models caches the automaton compiled for every content model.
+	models map[*ElementDecl]*contentModel
+
+	// stack holds the elements currently open.
+	stack []validElement
+
+	// ids holds the values of the ID attributes seen so far, and refs the IDREF values that must
+	// match one of them by the end of the document.
+	ids  map[string]bool
+	refs []idRef
+
+	// root is set once the root element was opened.
+	root bool
+}
+
+// validElement is an element that was opened but not closed yet.
+type validElement struct {
+	name *Name
+	pos  Position
+	decl *ElementDecl
+
+	// states holds the states of the content model automaton reached by the children so far.
+	states []int
+}
+
+// idRef is a value of an IDREF or IDREFS attribute.
+type idRef struct {
+	id   string
+	name *Name
+	pos  Position
+}
+
+// validate checks the token t against the DTD, t is nil once the input ends.
+func (d *Decoder) validate(t Token) error {
+	if d.valid == nil {
+		if d.dtd == nil {
+			if t == nil {
+				return nil
+			}
+			if _, ok := t.(*StartTag); ok {
+				return &ValidationError{Pos: d.tagPos, Reason: "missing <!DOCTYPE> declaration"}
+			}
+			return nil
+		}
+		d.valid = &validator{
+			dtd:    d.dtd,
+			models: make(map[*ElementDecl]*contentModel),
+			ids:    make(map[string]bool),
+		}
+	}
+	v := d.valid
+
+	switch t := t.(type) {
+	case nil:
+		return v.end()
+	case *StartTag:
+		return v.start(t, d.tagPos)
+	case *CloseTag:
+		return v.close(d.tagPos)
+	case *CharData:
+		pos := d.textPos
+		if t.CDATA {
+			pos = d.tagPos
+		}
+		return v.charData(t, pos)
+	}
+	return nil
+}
+
+// start checks that the element is allowed within its parent and that its attributes are valid.
+func (v *validator) start(t *StartTag, pos Position) error {
+	name := t.Name.String()
+	invalid := func(format string, args ...interface{}) error {
+		return &ValidationError{Name: t.Name, Pos: pos, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if len(v.stack) == 0 {
+		if v.root {
+			return invalid("only one root element is allowed")
+		}
+		v.root = true
+		if name != v.dtd.Name {
+			return invalid("root element must be <%s>", v.dtd.Name)
+		}
+	} else if err := v.child(name); err != nil {
+		return invalid("%s", err)
+	}
+
+	decl := v.dtd.Element(name)
+	if decl == nil {
+		return invalid("element is not declared")
+	}
+	el := validElement{name: t.Name, pos: pos, decl: decl}
+	if decl.Kind == ContentChildren {
+		el.states = v.model(decl).start()
+	}
+	v.stack = append(v.stack, el)
+
+	defs := v.dtd.Attributes(name)
+	for _, a := range t.Attr {
+		def := findAttDef(defs, a.Name.String())
+		if def == nil {
+			return invalid("attribute %s is not declared", a.Name)
+		}
+		if err := v.attr(def, a.Value, t.Name, pos); err != nil {
+			return invalid("attribute %s %s", a.Name, err)
+		}
+	}
+	for _, def := range defs {
+		if def.Default == "#REQUIRED" && !hasAttrNamed(t.Attr, def.Name) {
+			return invalid("missing required attribute %s", def.Name)
+		}
+	}
+	return nil
+}
+
+// child checks that the last open element allows a child element named name.
+func (v *validator) child(name string) error {
+	parent := &v.stack[len(v.stack)-1]
+	decl := parent.decl
+	switch decl.Kind {
+	case ContentEmpty:
+		return fmt.Errorf("not allowed in EMPTY element <%s>", decl.Name)
+	case ContentMixed:
+		for _, n := range decl.Mixed {
+			if n == name {
+				return nil
+			}
+		}
+		return fmt.Errorf("not allowed in <%s>, expected %s", decl.Name, elementList(decl.Mixed, "text"))
+	case ContentChildren:
+		m := v.model(decl)
+		next := m.step(parent.states, name)
+		if len(next) == 0 {
+			return fmt.Errorf("not allowed here in <%s>, expected %s", decl.Name, m.expected(parent.states))
+		}
+		parent.states = next
+	}
+	return nil
+}
+
+// close checks that the last open element has all the children its content model requires.
+func (v *validator) close(pos Position) error {
+	el := v.stack[len(v.stack)-1]
+	v.stack = v.stack[:len(v.stack)-1]
+	if el.decl.Kind != ContentChildren {
+		return nil
+	}
+	if m := v.model(el.decl); !m.accepts(el.states) {
+		return &ValidationError{Name: el.name, Pos: pos, Reason: fmt.Sprintf("unexpected end of element, expected %s", m.expected(el.states))}
+	}
+	return nil
+}
+
+// charData checks that the last open element allows text, only whitespace is allowed within
+// elements that can only contain other elements.
+func (v *validator) charData(t *CharData, pos Position) error {
+	if len(v.stack) == 0 {
+		return nil
+	}
+	el := v.stack[len(v.stack)-1]
+	switch el.decl.Kind {
+	case ContentEmpty:
+		if len(t.Data) > 0 {
+			return &ValidationError{Name: el.name, Pos: pos, Reason: "text not allowed in EMPTY element"}
+		}
+	case ContentChildren:
+		if t.CDATA || len(strings.TrimSpace(string(t.Data))) > 0 {
+			return &ValidationError{Name: el.name, Pos: pos, Reason: "text not allowed, only elements"}
+		}
+	}
+	return nil
+}
+
+// end checks that every IDREF matches an ID once the input ends.
+func (v *validator) end() error {
+	for _, ref := range v.refs {
+		if !v.ids[ref.id] {
+			return &ValidationError{Name: ref.name, Pos: ref.pos, Reason: fmt.Sprintf("IDREF %q doesn't match any ID", ref.id)}
+		}
+	}
+	v.refs = nil
+	return nil
+}
+
+// attr checks the value of an attribute against its definition.
+func (v *validator) attr(def *AttDef, value string, element *Name, pos Position) error {
+	switch def.Type {
+	case "ID":
+		if !isName(value) {
+			return fmt.Errorf("value %q is not a valid ID", value)
+		}
+		if v.ids[value] {
+			return fmt.Errorf("value %q is already used as an ID", value)
+		}
+		v.ids[value] = true
+	case "IDREF", "IDREFS":
+		ids := []string{value}
+		if def.Type == "IDREFS" {
+			ids = strings.Fields(value)
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("must not be empty")
+		}
+		for _, id := range ids {
+			if !isName(id) {
+				return fmt.Errorf("value %q is not a valid IDREF", id)
+			}
+			v.refs = append(v.refs, idRef{id: id, name: element, pos: pos})
+		}
+	case "ENTITY", "ENTITIES":
+		names := []string{value}
+		if def.Type == "ENTITIES" {
+			names = strings.Fields(value)
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("must not be empty")
+		}
+		for _, name := range names {
+			if e := v.dtd.Entity(name); e == nil || e.NData == "" {
+				return fmt.Errorf("value %q is not an unparsed entity", name)
+			}
+		}
+	case "NMTOKEN", "NMTOKENS":
+		tokens := []string{value}
+		if def.Type == "NMTOKENS" {
+			tokens = strings.Fields(value)
+		}
+		if len(tokens) == 0 {
+			return fmt.Errorf("must not be empty")
+		}
+		for _, token := range tokens {
+			if !isNmtoken(token) {
+				return fmt.Errorf("value %q is not a valid NMTOKEN", token)
+			}
+		}
+	case "ENUMERATION", "NOTATION":
+		found := false
+		for _, e := range def.Enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value %q must be %s", value, valueList(def.Enum))
+		}
+	}
+	if def.Default == "#FIXED" && value != def.Value {
+		return fmt.Errorf("value %q must be %q", value, def.Value)
+	}
+	return nil
+}
+
+func findAttDef(defs []*AttDef, name string) *AttDef {
+	for _, def := range defs {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+func hasAttrNamed(attrs []*Attr, name string) bool {
+	for _, a := range attrs {
+		if a.Name.String() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isName reports whether s is a valid XML name, like the value of an ID attribute.
+func isName(s string) bool {
+	r, size := utf8.DecodeRuneInString(s)
+	return (isNameStartChar(r) || r == ':') && isNmtoken(s[size:])
+}
+
+// isNmtoken reports whether s only has name characters, like the value of an NMTOKEN attribute.
+func isNmtoken(s string) bool {
+	for _, r := range s {
+		if !isNameChar(r) && r != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// elementList lists element names for an error message like "<a>, <b> or text", other is added
+// last unless it's empty.
+func elementList(names []string, other string) string {
+	list := make([]string, 0, len(names)+1)
+	for _, n := range names {
+		list = append(list, "<"+n+">")
+	}
+	if other != "" {
+		list = append(list, other)
+	}
+	return orList(list)
+}
+
+// valueList lists attribute values for an error message like `"a", "b" or "c"`.
+func valueList(values []string) string {
+	list := make([]string, len(values))
+	for i, v := range values {
+		list[i] = fmt.Sprintf("%q", v)
+	}
+	return orList(list)
+}
+
+func orList(list []string) string {
+	switch len(list) {
+	case 0:
+		return "nothing"
+	case 1:
+		return list[0]
+	}
+	return strings.Join(list[:len(list)-1], ", ") + " or " + list[len(list)-1]
+}
+
+// model returns the automaton of the content model of decl.
+func (v *validator) model(decl *ElementDecl) *contentModel {
+	m := v.models[decl]
+	if m == nil {
+		m = compileModel(decl.Model)
+		v.models[decl] = m
+	}
+	return m
+}
+
+// contentModel is a nondeterministic automaton that matches the children of an element against a
+// content model like (a,(b|c)*). State 0 is the accepting state.
+type contentModel struct {
+	states []modelState
+	first  int
+}
+
+// modelState either consumes an element named name, or is an epsilon transition when name is empty.
+type modelState struct {
+	name string
+	next []int
+}
+
+func compileModel(cp *ContentParticle) *contentModel {
+	m := &contentModel{states: []modelState{{}}}
+	m.first = m.compile(cp, 0)
+	return m
+}
+
+// compile adds the states that match cp and then continue to the state target, and returns the
+// first of them.
+func (m *contentModel) compile(cp *ContentParticle, target int) int {
+	switch cp.Occurs {
+	case '?':
+		return m.add("", m.compileOnce(cp, target), target)
+	case '*', '+':
+		loop := m.add("")
+		first := m.compileOnce(cp, loop)
+		m.states[loop].next = []int{first, target}
+		if cp.Occurs == '+' {
+			return first
+		}
+		return loop
+	}
+	return m.compileOnce(cp, target)
+}
+
+// compileOnce is like compile ignoring the occurrence suffix of cp.
+func (m *contentModel) compileOnce(cp *ContentParticle, target int) int {
+	switch {
+	case cp.Name != "":
+		return m.add(cp.Name, target)
+	case cp.Choice:
+		s := m.add("")
+		var next []int
+		for _, c := range cp.Children {
+			next = append(next, m.compile(c, target))
+		}
+		m.states[s].next = next
+		return s
+	}
+	for i := len(cp.Children) - 1; i >= 0; i-- {
+		target = m.compile(cp.Children[i], target)
+	}
+	return target
+}
+
+func (m *contentModel) add(name string, next ...int) int {
+	m.states = append(m.states, modelState{name: name, next: next})
+	return len(m.states) - 1
+}
+
+// start returns the states before any child.
+func (m *contentModel) start() []int {
+	return m.closure([]int{m.first})
+}
+
+// closure adds the states reached through epsilon transitions.
+func (m *contentModel) closure(states []int) []int {
+	seen := make([]bool, len(m.states))
+	var out []int
+	var visit func(s int)
+	visit = func(s int) {
+		if seen[s] {
+			return
+		}
+		seen[s] = true
+		out = append(out, s)
+		if m.states[s].name == "" {
+			for _, n := range m.states[s].next {
+				visit(n)
+			}
+		}
+	}
+	for _, s := range states {
+		visit(s)
+	}
+	return out
+}
+
+// step returns the states reached by a child named name, it's empty if the child isn't allowed.
+func (m *contentModel) step(states []int, name string) []int {
+	var next []int
+	for _, s := range states {
+		if m.states[s].name == name {
+			next = append(next, m.states[s].next...)
+		}
+	}
+	if len(next) == 0 {
+		return nil
+	}
+	return m.closure(next)
+}
+
+// accepts reports whether the element can end after reaching states.
+func (m *contentModel) accepts(states []int) bool {
+	for _, s := range states {
+		if s == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// expected lists the children allowed after reaching states for error messages.
+func (m *contentModel) expected(states []int) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, s := range states {
+		if n := m.states[s].name; n != "" && !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	other := ""
+	if m.accepts(states) {
+		other = "the end of the element"
+	}
+	return elementList(names, other)
+}
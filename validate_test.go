@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// bookDTD declares every kind of content model and the attribute types checked by Validate.
+const bookDTD = `<!DOCTYPE book [
+<!ELEMENT book (title, author+, (chapter | appendix)*, index?)>
+<!ATTLIST book lang (en|es) "en" edition CDATA #FIXED "1">
+<!ELEMENT title (#PCDATA)>
+<!ELEMENT author (#PCDATA)>
+<!ELEMENT chapter (#PCDATA | ref | br)*>
+<!ATTLIST chapter id ID #REQUIRED>
+<!ELEMENT appendix ANY>
+<!ELEMENT ref EMPTY>
+<!ATTLIST ref to IDREF #REQUIRED also IDREFS #IMPLIED size NMTOKEN #IMPLIED img ENTITY #IMPLIED>
+<!ELEMENT br EMPTY>
+<!ELEMENT index EMPTY>
+<!ENTITY cover SYSTEM "cover.gif" NDATA gif>
+<!NOTATION gif SYSTEM "image/gif">
+]>
+`
+
+func TestValidate(t *testing.T) {
+	const input = bookDTD + `<book lang="es">
+  <title>Go</title>
+  <author>Rob</author>
+  <author>Ken</author>
+  <chapter id="c1">See <ref to="c2" also="c1 c2" size="10pt" img="cover"/>.<br/></chapter>
+  <appendix><title>Any</title> text</appendix>
+  <chapter id="c2"/>
+  <index/>
+</book>`
+	d := NewDecoder(strings.NewReader(input))
+	d.Validate = true
+	var names []string
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start, ok := tok.(*StartTag); ok {
+			names = append(names, start.Name.String())
+		}
+	}
+	want := []string{"book", "title", "author", "author", "chapter", "ref", "br", "appendix", "title", "chapter", "index"}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Error("StartTag diff (-want +got)\n", diff)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	// The documents start at row 16, after bookDTD.
+	testCases := []struct {
+		desc  string
+		input string
+		want  string
+		pos   Position
+	}{
+		{"no DOCTYPE", `<book/>`, "missing <!DOCTYPE> declaration", Position{1, 1}},
+		{"wrong root", bookDTD + `<title/>`, "root element must be <book>", Position{16, 1}},
+		{"undeclared element", bookDTD + `<book><title/><author/><foo/></book>`, "not allowed here in <book>, expected <appendix>, <author>, <chapter>, <index> or the end of the element", Position{16, 24}},
+		{"missing child", bookDTD + `<book><title/></book>`, "unexpected end of element, expected <author>", Position{16, 15}},
+		{"wrong order", bookDTD + `<book><author/><title/></book>`, "not allowed here in <book>, expected <title>", Position{16, 7}},
+		{"after optional", bookDTD + `<book><title/><author/><index/><chapter id="a"/></book>`, "expected the end of the element", Position{16, 32}},
+		{"text in children", bookDTD + `<book>Go</book>`, "text not allowed, only elements", Position{16, 7}},
+		{"text in EMPTY", bookDTD + `<book><title/><author/><index>x</index></book>`, "text not allowed in EMPTY element", Position{16, 31}},
+		{"child in EMPTY", bookDTD + `<book><title/><author/><index><br/></index></book>`, "not allowed in EMPTY element <index>", Position{16, 31}},
+		{"child in mixed", bookDTD + `<book><title/><author/><chapter id="a"><title/></chapter></book>`, "not allowed in <chapter>, expected <ref>, <br> or text", Position{16, 40}},
+		{"undeclared attribute", bookDTD + `<book foo="bar"/>`, "attribute foo is not declared", Position{16, 1}},
+		{"missing required", bookDTD + `<book><title/><author/><chapter/></book>`, "missing required attribute id", Position{16, 24}},
+		{"enumeration", bookDTD + `<book lang="fr"/>`, `attribute lang value "fr" must be "en" or "es"`, Position{16, 1}},
+		{"fixed", bookDTD + `<book edition="2"/>`, `attribute edition value "2" must be "1"`, Position{16, 1}},
+		{"invalid ID", bookDTD + `<book><title/><author/><chapter id="1"/></book>`, `attribute id value "1" is not a valid ID`, Position{16, 24}},
+		{"duplicate ID", bookDTD + "<book><title/><author/>\n<chapter id=\"a\"/><chapter id=\"a\"/></book>", `attribute id value "a" is already used as an ID`, Position{17, 18}},
+		{"dangling IDREF", bookDTD + "<book><title/><author/>\n<chapter id=\"a\"><ref to=\"b\"/></chapter></book>", `IDREF "b" doesn't match any ID`, Position{17, 17}},
+		{"dangling IDREFS", bookDTD + `<book><title/><author/><chapter id="a"><ref to="a" also="a b"/></chapter></book>`, `IDREF "b" doesn't match any ID`, Position{16, 40}},
+		{"invalid NMTOKEN", bookDTD + `<book><title/><author/><chapter id="a"><ref to="a" size="1 pt"/></chapter></book>`, `attribute size value "1 pt" is not a valid NMTOKEN`, Position{16, 40}},
+		{"invalid ENTITY", bookDTD + `<book><title/><author/><chapter id="a"><ref to="a" img="back"/></chapter></book>`, `attribute img value "back" is not an unparsed entity`, Position{16, 40}},
+		{"mismatched tags", bookDTD + `<book><title></book>`, "close tag </book> at row: 16 col: 14 does not match start tag <title>", Position{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tc.input))
+			d.Validate = true
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+			var verr *ValidationError
+			if errors.As(err, &verr) && verr.Pos != tc.pos {
+				t.Errorf("Pos: %s want %s", verr.Pos, tc.pos)
+			}
+		})
+	}
+}
@@ -0,0 +1,570 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Marshaler is the interface implemented by objects that can marshal themselves into valid XML
+// elements.
+//
+// MarshalXML encodes the receiver as zero or more XML elements, usually by calling
+// `e.EncodeElement` or `e.EncodeToken`. The start tag holds the name the element would have
+// otherwise, it may be modified or ignored. Every StartTag written must have its CloseTag.
+//
+// The signature differs from `encoding/xml.Marshaler`, which `go vet` reports on every
+// implementation unless run with `-stdmethods=false`.
+type Marshaler interface {
+	MarshalXML(e *Encoder, start StartTag) error
+}
+
+// MarshalerAttr is the interface implemented by objects that can marshal themselves into valid XML
+// attributes.
+//
+// MarshalXMLAttr returns an XML attribute with the encoded value of the receiver, name is the
+// attribute name it would have otherwise. Returning an Attr without Name omits the attribute.
+type MarshalerAttr interface {
+	MarshalXMLAttr(name *Name) (Attr, error)
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	marshalerAttrType = reflect.TypeOf((*MarshalerAttr)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Marshal returns the XML encoding of v.
+//
+// Structs are written as an element containing their fields, using the same `xml` struct tags as
+// `Unmarshal`. The element name is taken from, in order of precedence, the XMLName field, the
+// struct field name or tag that holds the value, or the name of the type. Slices and arrays write
+// one element per item, and nil pointers and interfaces are omitted.
+//
+// Fields tagged with `omitempty` are not written when they hold an empty value: false, 0, a nil
+// pointer or interface, or an empty array, slice, map, or string.
+//
+// Types implementing `Marshaler` or `encoding.TextMarshaler` encode themselves, and attribute
+// fields may implement `MarshalerAttr` or `encoding.TextMarshaler` instead.
+func Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// MarshalIndent works like Marshal, but each XML element begins on a new indented line that starts
+// with prefix and is followed by one or more copies of indent according to the nesting depth.
+func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.Indent(prefix, indent)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Encode writes the XML encoding of v to the stream.
+//
+// See `Marshal` for details about the conversion of Go values to XML.
+func (e *Encoder) Encode(v interface{}) error {
+	if err := e.marshalValue(reflect.ValueOf(v), nil, nil); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// EncodeElement writes the XML encoding of v to the stream, using start as the outermost tag in
+// the encoding.
+//
+// See `Marshal` for details about the conversion of Go values to XML.
+func (e *Encoder) EncodeElement(v interface{}, start StartTag) error {
+	if err := e.marshalValue(reflect.ValueOf(v), nil, &start); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// commentEnd is the sequence that comments must not contain.
+var commentEnd = []byte("--")
+
+// marshalValue writes one or more XML elements representing val.
+//
+// If val was obtained from a struct field, finfo must have its details.
+func (e *Encoder) marshalValue(val reflect.Value, finfo *fieldInfo, startTemplate *StartTag) error {
+	if startTemplate != nil && (startTemplate.Name == nil || startTemplate.Name.local == "") {
+		return errors.New("start tag with missing name")
+	}
+
+	if !val.IsValid() {
+		return nil
+	}
+	if finfo != nil && finfo.flags&fOmitEmpty != 0 && isEmptyValue(val) {
+		return nil
+	}
+
+	// Drill into interfaces and pointers.
+	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	kind := val.Kind()
+	typ := val.Type()
+
+	// Check for marshaler.
+	if val.CanInterface() && typ.Implements(marshalerType) {
+		return e.marshalInterface(val.Interface().(Marshaler), defaultStart(typ, finfo, startTemplate))
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(marshalerType) {
+			return e.marshalInterface(pv.Interface().(Marshaler), defaultStart(typ, finfo, startTemplate))
+		}
+	}
+
+	// Check for text marshaler.
+	if m, ok := textMarshaler(val); ok {
+		return e.marshalTextInterface(m, defaultStart(typ, finfo, startTemplate))
+	}
+
+	// Slices and arrays iterate over the elements. They do not have an enclosing tag.
+	if (kind == reflect.Slice || kind == reflect.Array) && typ.Elem().Kind() != reflect.Uint8 {
+		for i, n := 0, val.Len(); i < n; i++ {
+			if err := e.marshalValue(val.Index(i), finfo, startTemplate); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tinfo, err := getTypeInfo(typ)
+	if err != nil {
+		return err
+	}
+
+	// Create start element.
+	// Precedence for the XML element name is:
+	// 0. startTemplate
+	// 1. XMLName field in underlying struct;
+	// 2. field name/tag in the struct field; and
+	// 3. type name
+	var start StartTag
+	if startTemplate != nil {
+		start = *startTemplate
+	} else if tinfo.xmlname != nil {
+		xmlname := tinfo.xmlname
+		if xmlname.ident != nil {
+			start.Name = xmlname.ident
+		} else if fv := xmlname.valueIfSet(val); fv.IsValid() {
+			switch v := fv.Interface().(type) {
+			case Name:
+				if v.local != "" {
+					start.Name = &v
+				}
+			case *Name:
+				if v != nil && v.local != "" {
+					start.Name = v
+				}
+			}
+		}
+	}
+	if start.Name == nil && finfo != nil {
+		start.Name = finfo.ident
+	}
+	if start.Name == nil {
+		name := typ.Name()
+		if name == "" {
+			return fmt.Errorf("unsupported type %s", typ)
+		}
+		start.Name = &Name{local: name}
+	}
+
+	e.openStartTag(&start)
+
+	// Attributes
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fAttr == 0 {
+			continue
+		}
+		fv := finfo.valueIfSet(val)
+		if !fv.IsValid() {
+			continue
+		}
+		if finfo.flags&fOmitEmpty != 0 && isEmptyValue(fv) {
+			continue
+		}
+		name := finfo.ident
+		if finfo.flags&fAny != 0 {
+			// Only Attr values have a name of their own.
+			name = nil
+		}
+		if err := e.marshalAttr(name, fv); err != nil {
+			return err
+		}
+	}
+	e.w.WriteByte('>')
+
+	if kind == reflect.Struct {
+		err = e.marshalStruct(tinfo, val)
+	} else {
+		err = e.marshalSimple(val)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.writeEnd(start.Name)
+	return nil
+}
+
+// defaultStart returns the StartTag for a type that marshals itself. The name is taken from the
+// template, the struct field, the tag of the XMLName field, or the type name. The value of the
+// XMLName field is never used.
+func defaultStart(typ reflect.Type, finfo *fieldInfo, startTemplate *StartTag) StartTag {
+	switch {
+	case startTemplate != nil:
+		return *startTemplate
+	case finfo != nil && finfo.ident != nil:
+		return StartTag{Name: finfo.ident}
+	}
+	if typ.Kind() == reflect.Struct {
+		if tinfo, err := getTypeInfo(typ); err == nil && tinfo.xmlname != nil && tinfo.xmlname.ident != nil {
+			return StartTag{Name: tinfo.xmlname.ident}
+		}
+	}
+	return StartTag{Name: &Name{local: typ.Name()}}
+}
+
+// marshalInterface calls the Marshaler and verifies that every tag it opened was closed.
+func (e *Encoder) marshalInterface(val Marshaler, start StartTag) error {
+	n := len(e.tags)
+	if err := val.MarshalXML(e, start); err != nil {
+		return err
+	}
+	switch {
+	case len(e.tags) > n:
+		return fmt.Errorf("%T.MarshalXML wrote invalid XML: <%s> not closed", val, e.tags[len(e.tags)-1])
+	case len(e.tags) < n:
+		return fmt.Errorf("%T.MarshalXML wrote invalid XML: closed tags it didn't open", val)
+	}
+	return nil
+}
+
+// marshalTextInterface writes an element with the text of the TextMarshaler as its CharData.
+func (e *Encoder) marshalTextInterface(val encoding.TextMarshaler, start StartTag) error {
+	if start.Name == nil || start.Name.local == "" {
+		return errors.New("start tag with missing name")
+	}
+	text, err := val.MarshalText()
+	if err != nil {
+		return err
+	}
+	e.openStartTag(&start)
+	e.w.WriteByte('>')
+	if err := escapeText(e.w, text, false); err != nil {
+		return err
+	}
+	e.writeEnd(start.Name)
+	return nil
+}
+
+// textMarshaler returns the encoding.TextMarshaler implemented by val or its address.
+func textMarshaler(val reflect.Value) (encoding.TextMarshaler, bool) {
+	if val.CanInterface() && val.Type().Implements(textMarshalerType) {
+		return val.Interface().(encoding.TextMarshaler), true
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(textMarshalerType) {
+			return pv.Interface().(encoding.TextMarshaler), true
+		}
+	}
+	return nil, false
+}
+
+// marshalAttr writes the attribute(s) held by val.
+func (e *Encoder) marshalAttr(name *Name, val reflect.Value) error {
+	// Dereference or skip nil pointer, interface values.
+	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	if val.CanInterface() && val.Type().Implements(marshalerAttrType) {
+		return e.marshalAttrInterface(val.Interface().(MarshalerAttr), name)
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(marshalerAttrType) {
+			return e.marshalAttrInterface(pv.Interface().(MarshalerAttr), name)
+		}
+	}
+	if m, ok := textMarshaler(val); ok {
+		if name == nil {
+			return fmt.Errorf("unsupported type %s for any attribute, must be Attr", val.Type())
+		}
+		text, err := m.MarshalText()
+		if err != nil {
+			return err
+		}
+		e.writeAttr(name, text)
+		return nil
+	}
+
+	if val.Type() == attrType {
+		attr := val.Interface().(Attr)
+		if attr.Name != nil && attr.Name.local != "" {
+			e.buf = append(e.buf[:0], attr.Value...)
+			e.writeAttr(attr.Name, e.buf)
+		}
+		return nil
+	}
+
+	// Walk slices.
+	if val.Kind() == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8 {
+		n := val.Len()
+		for i := 0; i < n; i++ {
+			if err := e.marshalAttr(name, val.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if name == nil {
+		return fmt.Errorf("unsupported type %s for any attribute, must be Attr", val.Type())
+	}
+	var err error
+	e.buf, err = appendSimple(e.buf[:0], val)
+	if err != nil {
+		return err
+	}
+	e.writeAttr(name, e.buf)
+	return nil
+}
+
+// marshalAttrInterface writes the attribute returned by the MarshalerAttr.
+func (e *Encoder) marshalAttrInterface(val MarshalerAttr, name *Name) error {
+	attr, err := val.MarshalXMLAttr(name)
+	if err != nil {
+		return err
+	}
+	if attr.Name != nil && attr.Name.local != "" {
+		e.buf = append(e.buf[:0], attr.Value...)
+		e.writeAttr(attr.Name, e.buf)
+	}
+	return nil
+}
+
+// marshalSimple writes the escaped text of a basic value.
+func (e *Encoder) marshalSimple(val reflect.Value) error {
+	var err error
+	e.buf, err = appendSimple(e.buf[:0], val)
+	if err != nil {
+		return err
+	}
+	return escapeText(e.w, e.buf, false)
+}
+
+// appendSimple appends the text representation of a basic value to buf.
+func appendSimple(buf []byte, val reflect.Value) ([]byte, error) {
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(buf, val.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(buf, val.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.AppendFloat(buf, val.Float(), 'g', -1, val.Type().Bits()), nil
+	case reflect.String:
+		return append(buf, val.String()...), nil
+	case reflect.Bool:
+		return strconv.AppendBool(buf, val.Bool()), nil
+	case reflect.Array:
+		if val.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		for i, n := 0, val.Len(); i < n; i++ {
+			buf = append(buf, byte(val.Index(i).Uint()))
+		}
+		return buf, nil
+	case reflect.Slice:
+		if val.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		return append(buf, val.Bytes()...), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", val.Type())
+}
+
+// marshalStruct writes the contents of the struct element, the attributes must be written already.
+func (e *Encoder) marshalStruct(tinfo *typeInfo, val reflect.Value) error {
+	s := parentStack{e: e}
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fAttr != 0 {
+			continue
+		}
+		vf := finfo.valueIfSet(val)
+		if !vf.IsValid() {
+			// The field is behind an anonymous struct field that's nil. Skip it.
+			continue
+		}
+
+		switch finfo.flags & fMode {
+		case fCharData, fInnerXML, fComment:
+			s.trim(nil)
+			for vf.Kind() == reflect.Interface || vf.Kind() == reflect.Ptr {
+				if vf.IsNil() {
+					break
+				}
+				vf = vf.Elem()
+			}
+			if vf.Kind() == reflect.Interface || vf.Kind() == reflect.Ptr {
+				continue
+			}
+		}
+
+		switch finfo.flags & fMode {
+		case fCharData:
+			if m, ok := textMarshaler(vf); ok {
+				text, err := m.MarshalText()
+				if err != nil {
+					return err
+				}
+				if err := escapeText(e.w, text, false); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := e.marshalSimple(vf); err != nil {
+				return err
+			}
+			continue
+
+		case fInnerXML:
+			switch vf.Kind() {
+			case reflect.String:
+				e.w.WriteString(vf.String())
+				continue
+			case reflect.Slice:
+				if vf.Type().Elem().Kind() == reflect.Uint8 {
+					e.w.Write(vf.Bytes())
+					continue
+				}
+			}
+			return fmt.Errorf("bad type for innerxml field of %s", val.Type())
+
+		case fComment:
+			k := vf.Kind()
+			if !(k == reflect.String || k == reflect.Slice && vf.Type().Elem().Kind() == reflect.Uint8) {
+				return fmt.Errorf("bad type for comment field of %s", val.Type())
+			}
+			if vf.Len() == 0 {
+				continue
+			}
+			e.buf, _ = appendSimple(e.buf[:0], vf)
+			if bytes.Contains(e.buf, commentEnd) {
+				return fmt.Errorf(`comments must not contain "--"`)
+			}
+			e.writeIndent(0)
+			e.w.WriteString("<!--")
+			e.w.Write(e.buf)
+			if e.buf[len(e.buf)-1] == '-' {
+				// "--->" is invalid grammar. Make it "- -->"
+				e.w.WriteByte(' ')
+			}
+			e.w.WriteString("-->")
+			continue
+
+		case fElement, fElement | fAny:
+			s.trim(finfo.parents)
+			if len(finfo.parents) > len(s.stack) {
+				if vf.Kind() != reflect.Ptr && vf.Kind() != reflect.Interface || !vf.IsNil() {
+					s.push(finfo.parents[len(s.stack):])
+				}
+			}
+		}
+		if err := e.marshalValue(vf, finfo, nil); err != nil {
+			return err
+		}
+	}
+	s.trim(nil)
+	return nil
+}
+
+// parentStack writes the parent elements of fields with paths like `xml:"a>b>c"`, so consecutive
+// fields with the same parents share them.
+type parentStack struct {
+	e     *Encoder
+	stack []*Name
+}
+
+// trim updates the XML context to match the longest common prefix of the stack and the given
+// parents. A closing tag will be written for every parent popped. Passing a zero slice or nil will
+// close all the elements.
+func (s *parentStack) trim(parents []string) {
+	split := 0
+	for ; split < len(parents) && split < len(s.stack); split++ {
+		if parents[split] != s.stack[split].local {
+			break
+		}
+	}
+	for i := len(s.stack) - 1; i >= split; i-- {
+		s.e.writeEnd(s.stack[i])
+	}
+	s.stack = s.stack[:split]
+}
+
+// push adds parent elements to the stack and writes open tags.
+func (s *parentStack) push(parents []string) {
+	for _, parent := range parents {
+		name := &Name{local: parent}
+		s.e.openStartTag(&StartTag{Name: name})
+		s.e.w.WriteByte('>')
+		s.stack = append(s.stack, name)
+	}
+}
+
+// isEmptyValue reports whether v holds the empty value for its type, used by `omitempty`.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type marshalFields struct {
+	XMLName  Name     `xml:"fields"`
+	Attr     string   `xml:"attr,attr"`
+	Empty    string   `xml:"empty,attr,omitempty"`
+	AnyAttr  []Attr   `xml:",any,attr"`
+	Int      int      `xml:"int"`
+	Uint     uint8    `xml:"uint"`
+	Float    float32  `xml:"float"`
+	Bool     bool     `xml:"bool"`
+	Bytes    []byte   `xml:"bytes"`
+	Ptr      *string  `xml:"ptr"`
+	NilPtr   *string  `xml:"nil"`
+	Omit     int      `xml:"omit,omitempty"`
+	Deep     []string `xml:"a>b>c"`
+	Sibling  string   `xml:"a>d"`
+	Comment  string   `xml:",comment"`
+	Text     string   `xml:",chardata"`
+	Iface    interface{}
+	Ignored  string `xml:"-"`
+	unexport string
+}
+
+func TestMarshal(t *testing.T) {
+	ptr := "pointer"
+	v := marshalFields{
+		Attr:     `"quoted" & <tagged>`,
+		AnyAttr:  []Attr{{Name: &Name{local: "x"}, Value: "1"}, {Name: &Name{space: "ns", local: "y"}, Value: "\t2\n"}},
+		Int:      -1,
+		Uint:     255,
+		Float:    0.5,
+		Bool:     true,
+		Bytes:    []byte("bytes"),
+		Ptr:      &ptr,
+		Deep:     []string{"1", "2"},
+		Sibling:  "s",
+		Comment:  "note",
+		Text:     "a < b\n",
+		Iface:    xmbPlaceholder{Name: "ph", Example: "ex"},
+		Ignored:  "ignored",
+		unexport: "unexported",
+	}
+
+	const want = `<fields attr="&#34;quoted&#34; &amp; &lt;tagged&gt;" x="1" ns:y="&#x9;2&#xA;">` +
+		`<int>-1</int><uint>255</uint><float>0.5</float><bool>true</bool><bytes>bytes</bytes>` +
+		`<ptr>pointer</ptr><a><b><c>1</c><c>2</c></b><d>s</d></a><!--note-->a &lt; b` + "\n" +
+		`<Iface name="ph"><ex>ex</ex></Iface></fields>`
+
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Error("Marshal diff (-want +got)\n", diff)
+	}
+}
+
+func TestMarshalIndent(t *testing.T) {
+	v := xmbBundle{
+		Msgs: []xmbMsg{
+			{ID: "1", Desc: "flying mammal", Source: []string{"a.go", "b.go"}, Text: "Bat"},
+			{ID: "2", Desc: "baseball item", Meaning: "sport"},
+		},
+	}
+
+	const want = `> <messagebundle>
+>   <msg id="1" desc="flying mammal">
+>     <source>a.go</source>
+>     <source>b.go</source>Bat
+>   </msg>
+>   <msg id="2" desc="baseball item" meaning="sport"></msg>
+> </messagebundle>`
+
+	got, err := MarshalIndent(v, "> ", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Error("MarshalIndent diff (-want +got)\n", diff)
+	}
+}
+
+type roundTrip struct {
+	XMLName Name             `xml:"msg"`
+	ID      string           `xml:"id,attr"`
+	Seq     int              `xml:"seq,attr,omitempty"`
+	Source  []string         `xml:"sources>source"`
+	Ph      []xmbPlaceholder `xml:"ph"`
+	Text    string           `xml:",chardata"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	want := roundTrip{
+		XMLName: Name{local: "msg"},
+		ID:      `"1" & '2'`,
+		Seq:     2,
+		Source:  []string{"a.go", "<b>.go"},
+		Ph:      []xmbPlaceholder{{Name: "x", Example: "ex"}},
+		Text:    "Bat & ball",
+	}
+
+	data, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got roundTrip
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(Name{})); diff != "" {
+		t.Error("round trip diff (-want +got)\n", diff)
+	}
+}
+
+func TestEncodeElement(t *testing.T) {
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	start := StartTag{Name: &Name{local: "value"}, Attr: []*Attr{{Name: &Name{local: "unit"}, Value: "ms"}}}
+	if err := e.EncodeElement(42, start); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Encode(true); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `<value unit="ms">42</value><bool>true</bool>`
+	if got := b.String(); got != want {
+		t.Errorf("EncodeElement: %s, want %s", got, want)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	testCases := []struct {
+		desc string
+		v    interface{}
+		want string
+	}{
+		{"unnamed type", struct{ A int }{}, "unsupported type struct { A int }"},
+		{"unsupported field", struct {
+			XMLName Name `xml:"a"`
+			M       map[string]string
+		}{M: map[string]string{}}, "unsupported type map[string]string"},
+		{"bad comment", struct {
+			XMLName Name   `xml:"a"`
+			C       string `xml:",comment"`
+		}{C: "a--b"}, `comments must not contain "--"`},
+		{"bad comment type", struct {
+			XMLName Name `xml:"a"`
+			C       int  `xml:",comment"`
+		}{C: 1}, "bad type for comment field"},
+		{"bad any attr", struct {
+			XMLName Name `xml:"a"`
+			A       int  `xml:",any,attr"`
+		}{A: 1}, "unsupported type int for any attribute, must be Attr"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := Marshal(tc.v)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err: '%s' want '%s'", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	var b bytes.Buffer
+	if err := EscapeText(&b, []byte("<a href='x'>\"&\"\t\n\r\x00</a>")); err != nil {
+		t.Fatal(err)
+	}
+	const want = "&lt;a href=&#39;x&#39;&gt;&#34;&amp;&#34;&#x9;&#xA;&#xD;\uFFFD&lt;/a&gt;"
+	if got := b.String(); got != want {
+		t.Errorf("EscapeText: %s, want %s", got, want)
+	}
+}
+
+// bracketed marshals itself as a start and close tag around its text in brackets.
+type bracketed string
+
+func (b bracketed) MarshalXML(e *Encoder, start StartTag) error {
+	start.Attr = append(start.Attr, &Attr{Name: NewName("", "custom"), Value: "true"})
+	if err := e.EncodeToken(&start); err != nil {
+		return err
+	}
+	if err := e.EncodeToken(&CharData{Data: []byte("[" + b + "]")}); err != nil {
+		return err
+	}
+	return e.EncodeToken(&CloseTag{Name: start.Name})
+}
+
+// upperAttrOut marshals itself as an upper case attribute, or no attribute if empty.
+type upperAttrOut string
+
+func (u *upperAttrOut) MarshalXMLAttr(name *Name) (Attr, error) {
+	if *u == "" {
+		return Attr{}, nil
+	}
+	return Attr{Name: name, Value: strings.ToUpper(string(*u))}, nil
+}
+
+// unbalanced opens a tag without closing it.
+type unbalanced struct{}
+
+func (unbalanced) MarshalXML(e *Encoder, start StartTag) error {
+	return e.EncodeToken(&start)
+}
+
+func TestMarshalInterfaces(t *testing.T) {
+	v := struct {
+		XMLName Name         `xml:"root"`
+		Upper   upperAttrOut `xml:"upper,attr"`
+		Skip    upperAttrOut `xml:"skip,attr"`
+		Time    time.Time    `xml:"time,attr"`
+		Custom  bracketed    `xml:"custom"`
+		Ptr     *bracketed   `xml:"ptr"`
+		List    []bracketed  `xml:"item"`
+		Values  csv          `xml:"values"`
+		Date    time.Time    `xml:"date"`
+	}{
+		Upper:  "up",
+		Time:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Custom: "c",
+		List:   []bracketed{"1", "2"},
+		Values: csv{"a", "b"},
+		Date:   time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	const want = `<root upper="UP" time="2020-01-02T03:04:05Z">` +
+		`<custom custom="true">[c]</custom><item custom="true">[1]</item><item custom="true">[2]</item>` +
+		`<values>a,b</values><date>2020-01-02T00:00:00Z</date></root>`
+
+	// Pointer so the fields are addressable and *upperAttrOut is found.
+	got, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Error("Marshal diff (-want +got)\n", diff)
+	}
+}
+
+func TestMarshalInterfaceErrors(t *testing.T) {
+	_, err := Marshal(struct {
+		XMLName Name       `xml:"a"`
+		U       unbalanced `xml:"u"`
+	}{})
+	const want = "xml.unbalanced.MarshalXML wrote invalid XML: <u> not closed"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("err: '%v' want '%s'", err, want)
+	}
+}
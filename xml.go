@@ -21,7 +21,7 @@ package xml
 //    Comment:   <-- foo -->
 //    ProcInst:  <? foo ?>
 //    Directive: <! foo >
-//    CharData:  Any string outside of angle brackets <>
+//    CharData:  Any string outside of angle brackets <>, or <![CDATA[ foo ]]>
 type Token interface {
 	token()
 
@@ -63,6 +63,10 @@ func (t *CloseTag) Copy() Token {
 // CharData contains a text node
 type CharData struct {
 	Data []byte
+
+	// CDATA is set when the text was read from a section like <![CDATA[ foo ]]>, its contents are
+	// kept verbatim. The Encoder writes it back as a CDATA section.
+	CDATA bool
 }
 
 func (*CharData) token() {}
@@ -70,7 +74,7 @@ func (*CharData) token() {}
 func (t *CharData) Copy() Token {
 	data := make([]byte, len(t.Data))
 	copy(data, t.Data)
-	return &CharData{data}
+	return &CharData{Data: data, CDATA: t.CDATA}
 }
 
 // Comment has the format <-- -->
@@ -87,19 +91,55 @@ func (*Comment) token() {}
 
 func (t *Comment) Copy() Token {
 	c := *t
+	if t.Data != nil {
+		c.Data = make([]byte, len(t.Data))
+		copy(c.Data, t.Data)
+	}
 	return &c
 }
 
-// ProcInst has the format <? ... ?>
-type ProcInst struct{}
+// ProcInst has the format <?target inst?>
+type ProcInst struct {
+	// Target is the name right after `<?`, like "xml" or "xml-stylesheet".
+	Target string
+
+	// Inst contains the rest of the processing instruction. It is empty by default.
+	//
+	// Enable `d.ReadProcInst` to include the contents in the token.
+	Inst []byte
+
+	// Decl holds the parsed fields of the XML declaration when Target is "xml", it is nil otherwise.
+	Decl *Declaration
+}
 
 func (*ProcInst) token() {}
 
 func (t *ProcInst) Copy() Token {
 	c := *t
+	if t.Inst != nil {
+		c.Inst = make([]byte, len(t.Inst))
+		copy(c.Inst, t.Inst)
+	}
+	if t.Decl != nil {
+		decl := *t.Decl
+		c.Decl = &decl
+	}
 	return &c
 }
 
+// Declaration contains the fields of an XML declaration like
+// <?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+type Declaration struct {
+	// Version is the XML version like "1.0", it is required.
+	Version string
+
+	// Encoding is the name of the character encoding, it is empty when not declared.
+	Encoding string
+
+	// Standalone is set by `standalone="yes"`, the default is "no" when not declared.
+	Standalone bool
+}
+
 // Directive has the format <! ... >
 //
 // Note: We do NOT process the directive token. We only read it.
@@ -114,6 +154,10 @@ func (*Directive) token() {}
 
 func (t *Directive) Copy() Token {
 	c := *t
+	if t.Data != nil {
+		c.Data = make([]byte, len(t.Data))
+		copy(c.Data, t.Data)
+	}
 	return &c
 }
 
@@ -129,6 +173,29 @@ type Attr struct {
 type Name struct {
 	local string
 	space string
+
+	// uri is the namespace URI that the space prefix resolves to, see Decoder.Namespaces.
+	uri string
+}
+
+// NewName instantiates a Name with the given namespace and local name, for example to build tokens
+// for `Encoder.EncodeToken`.
+//
+// Names returned by a Decoder are interned and can be compared by pointer, but names created with
+// NewName are not, compare their Local and Space values instead.
+func NewName(space, local string) *Name {
+	return &Name{local: local, space: space}
+}
+
+// String returns the identifier as written in XML like "a:b", or just "b" without namespace.
+func (n *Name) String() string {
+	if n == nil {
+		return ""
+	}
+	if n.space == "" {
+		return n.local
+	}
+	return n.space + ":" + n.local
 }
 
 // Local returns the identifier name without XML namespace.
@@ -142,7 +209,7 @@ func (n *Name) Local() string {
 	return string(n.local)
 }
 
-// Space returns the identifier name without XML namespace.
+// Space returns the XML namespace prefix of the identifier.
 //
 // For example <a:b> generates the local name "b" with namespace "a"
 // This method will return "a".
@@ -150,5 +217,22 @@ func (n *Name) Space() string {
 	if n == nil {
 		return ""
 	}
-	return string(n.local)
+	return n.space
+}
+
+// URI returns the namespace URI that the identifier's prefix is bound to by an `xmlns:prefix`
+// attribute, or by `xmlns` for tags without prefix. It's only resolved when Decoder.Namespaces is
+// set, and it's empty for names without namespace.
+//
+// For example <a:b xmlns:a="urn:x"> will return "urn:x".
+func (n *Name) URI() string {
+	if n == nil {
+		return ""
+	}
+	return n.uri
+}
+
+// inSpace reports whether ns is empty, or either the prefix or the resolved URI of the name.
+func (n *Name) inSpace(ns string) bool {
+	return ns == "" || ns == n.space || n.uri != "" && ns == n.uri
 }